@@ -1,52 +1,89 @@
 package config
 
 import (
-    "log"
-    "os"
+	"log"
+	"os"
+	"time"
 
-    "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-    Server struct {
-        Port string `yaml:"port"`
-    } `yaml:"server"`
-    MySQL struct {
-        DSN string `yaml:"dsn"`
-    } `yaml:"mysql"`
-    AI struct {
-        ImageAPI string `yaml:"image_api"`
-        VoiceAPI string `yaml:"voice_api"`
-    } `yaml:"ai"`
+	Server struct {
+		Port string `yaml:"port"`
+	} `yaml:"server"`
+	MySQL struct {
+		DSN string `yaml:"dsn"`
+	} `yaml:"mysql"`
+	AI struct {
+		ImageAPI string `yaml:"image_api"`
+		VoiceAPI string `yaml:"voice_api"`
+	} `yaml:"ai"`
 
-    Redis struct {
-        Addr     string `yaml:"addr"`
-        Password string `yaml:"password"`
-    } `yaml:"redis"`
-    Worker struct {
-        Addr string `yaml:"addr"` 
-    } `yaml:"worker"`
-    MinIO struct {
-        Endpoint  string `yaml:"endpoint"`
-        AccessKey string `yaml:"access_key"`
-        SecretKey string `yaml:"secret_key"`
-        Bucket    string `yaml:"bucket"`
-        UseSSL    bool   `yaml:"use_ssl"`
-        Domain    string `yaml:"domain"`
-    } `yaml:"minio"`
+	Redis struct {
+		Addr     string `yaml:"addr"`
+		Password string `yaml:"password"`
+	} `yaml:"redis"`
+	Worker struct {
+		Addr string `yaml:"addr"`
+		// UseCallback: true 时 Worker 通过 POST /v1/api/worker/callback 主动上报进度/结果，
+		// HandleGenerateTask 改为阻塞等待 service.JobHub 回调事件；false（默认）保留旧的
+		// pollJobResult 轮询路径，供尚未接入回调的 Worker 使用
+		UseCallback bool `yaml:"use_callback"`
+		// Heartbeats 按 task.Type（models.TaskType* 常量）配置 worker 应该多久调用一次
+		// PATCH /v1/api/tasks/:task_id/heartbeat；StallReaper 把 interval*3 没有心跳的
+		// processing 任务判定为 stalled。未出现在配置里的类型按兜底间隔处理，例如：
+		//   generate_shot: 60s
+		//   generate_video: 300s
+		Heartbeats map[string]time.Duration `yaml:"heartbeats"`
+	} `yaml:"worker"`
+	MinIO struct {
+		Endpoint  string `yaml:"endpoint"`
+		AccessKey string `yaml:"access_key"`
+		SecretKey string `yaml:"secret_key"`
+		Bucket    string `yaml:"bucket"`
+		UseSSL    bool   `yaml:"use_ssl"`
+		Domain    string `yaml:"domain"`
+	} `yaml:"minio"`
+	Moderation struct {
+		// Provider: mock | aliyun_green | local_nsfw，默认为 mock
+		Provider       string `yaml:"provider"`
+		Endpoint       string `yaml:"endpoint"`
+		AccessKey      string `yaml:"access_key"`
+		SecretKey      string `yaml:"secret_key"`
+		CallbackSecret string `yaml:"callback_secret"`
+	} `yaml:"moderation"`
+	Scheduler struct {
+		// TypeConcurrency 限制同一 task.Type 同时处于 processing 的任务数，键为
+		// models.TaskType* 常量，未出现在配置里的类型按 models.DefaultTypeConcurrency 兜底，
+		// 避免某个项目的大量 video/shot_image 任务占满 worker 饿死其它项目
+		TypeConcurrency map[string]int `yaml:"type_concurrency"`
+	} `yaml:"scheduler"`
+	Orchestrator struct {
+		// FailurePolicy: cascade_cancel | skip_and_continue，决定 TaskParameters.DependsOn
+		// 链路上父任务 failed/cancelled 时，被阻塞的子任务是跟着级联取消还是原样留在 blocked
+		// 等待父任务重试成功；默认为 cascade_cancel
+		FailurePolicy string `yaml:"failure_policy"`
+	} `yaml:"orchestrator"`
+	Batch struct {
+		// ShardTimeout 是单个分片任务允许运行的时长；BatchTCB 的存活时间取它的 2 倍，
+		// 超过仍未收齐所有分片就由 service.StartBatchReaper 判定批次 stalled。未配置时默认
+		// 10 分钟。
+		ShardTimeout time.Duration `yaml:"shard_timeout"`
+	} `yaml:"batch"`
 }
 
 var AppConfig *Config
 
 func InitConfig() {
-    f, err := os.Open("config/config.yaml")
-    if err != nil {
-        log.Fatalf("配置文件读取失败: %v", err)
-    }
-    defer f.Close()
-    decoder := yaml.NewDecoder(f)
-    AppConfig = &Config{}
-    if err := decoder.Decode(AppConfig); err != nil {
-        log.Fatalf("配置文件解析失败: %v", err)
-    }
-}
\ No newline at end of file
+	f, err := os.Open("config/config.yaml")
+	if err != nil {
+		log.Fatalf("配置文件读取失败: %v", err)
+	}
+	defer f.Close()
+	decoder := yaml.NewDecoder(f)
+	AppConfig = &Config{}
+	if err := decoder.Decode(AppConfig); err != nil {
+		log.Fatalf("配置文件解析失败: %v", err)
+	}
+}
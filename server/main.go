@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log"
+	"time"
+
 	"StoryToVideo-server/config"
 	"StoryToVideo-server/models"
 	"StoryToVideo-server/routers"
@@ -9,20 +14,46 @@ import (
 )
 
 func main() {
+	migrateOnly := flag.Bool("migrate", false, "应用完 schema_migrations 里缺的迁移后直接退出，不启动服务")
+	migrateDownTo := flag.Int("migrate-down-to", -1, "把 schema_migrations 回退到该版本后直接退出（-1 表示不执行，仅正向迁移）")
+	flag.Parse()
+
 	config.InitConfig()
 	fmt.Println("Server starting on port", config.AppConfig.Server.Port)
+	// InitDB 内部会自动把待执行的迁移应用完，失败则直接 Fatal，不会带着半成品的表结构启动
 	models.InitDB()
 	fmt.Println("Database initialized")
 
+	if *migrateDownTo >= 0 {
+		if err := models.MigrateDown(context.Background(), *migrateDownTo); err != nil {
+			log.Fatalf("迁移回退失败: %v", err)
+		}
+		fmt.Println("Schema migrations rolled back to version", *migrateDownTo)
+		return
+	}
+	if *migrateOnly {
+		fmt.Println("Schema migrations applied (-migrate), exiting without starting the server")
+		return
+	}
+
 	service.InitQueue()
 	fmt.Println("Queue initialized")
-	
+
+	service.InitEvents()
+	fmt.Println("Events initialized")
+
 	service.InitMinIO()
 	fmt.Println("MinIO initialized")
 	
 	processor := service.NewProcessor(models.GormDB)
 	processor.StartProcessor(5)
 
+	service.ResumeOrchestratorTasks(models.GormDB)
+
+	service.StartRetrySweeper(models.GormDB, 15*time.Second)
+	service.StartTaskScheduler(models.GormDB, 10*time.Second)
+	service.StartBatchReaper(models.GormDB, 30*time.Second)
+
 	r := routers.InitRouter()
 	r.Run(config.AppConfig.Server.Port)
 }
@@ -2,10 +2,10 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"strings"
 	"time"
@@ -47,21 +47,10 @@ func InitDB() {
 
 	log.Println("数据库连接成功 (Native SQL + GORM)")
 
-	// 自动建表（读取 doc/sql/StoryToVideo.sql）
-	b, err := ioutil.ReadFile("doc/sql/StoryToVideo.sql")
-	if err != nil {
-		log.Printf("读取 SQL 文件失败（跳过建表）: %v", err)
-		return
-	}
-	sqls := strings.Split(string(b), ";")
-	for _, s := range sqls {
-		s = strings.TrimSpace(s)
-		if s == "" {
-			continue
-		}
-		if _, err := DB.Exec(s); err != nil {
-			log.Printf("执行建表语句失败: %v ; sql: %s", err, s)
-		}
+	// 建表/改表一律走 models/migrations 下按版本号排列的迁移文件，逐条迁移单独开事务，
+	// 任意一条失败就中止启动，而不是像过去读 doc/sql/StoryToVideo.sql 那样打日志后继续。
+	if err := MigrateUp(context.Background(), 0); err != nil {
+		log.Fatalf("数据库迁移失败: %v", err)
 	}
 }
 
@@ -70,21 +59,30 @@ func CreateProject(p *Project) error {
 	now := time.Now()
 	p.CreatedAt = now
 	p.UpdatedAt = now
+	if p.Urgency == "" {
+		p.Urgency = ProjectUrgencyNormal
+	}
 	_, err := DB.Exec(
-		`INSERT INTO project (id, title, story_text, style, status, cover_image, duration, video_url, description, shot_count, created_at, updated_at)
-         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		p.ID, p.Title, p.StoryText, p.Style, p.Status, p.CoverImage, p.Duration, p.VideoUrl, p.Description, p.ShotCount, p.CreatedAt, p.UpdatedAt,
+		`INSERT INTO project (id, title, story_text, style, status, cover_image, duration, video_url, description, shot_count, urgency, created_at, updated_at)
+         VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.ID, p.Title, p.StoryText, p.Style, p.Status, p.CoverImage, p.Duration, p.VideoUrl, p.Description, p.ShotCount, p.Urgency, p.CreatedAt, p.UpdatedAt,
 	)
 	return err
 }
 
 func GetProjectByID(id string) (Project, error) {
 	var p Project
-	row := DB.QueryRow(`SELECT id, title, story_text, style, status, cover_image, duration, video_url, description, shot_count, created_at, updated_at FROM project WHERE id = ?`, id)
+	row := DB.QueryRow(`SELECT id, title, story_text, style, status, cover_image, duration, video_url, description, shot_count, urgency, created_at, updated_at FROM project WHERE id = ?`, id)
 	var createdAt, updatedAt time.Time
-	if err := row.Scan(&p.ID, &p.Title, &p.StoryText, &p.Style, &p.Status, &p.CoverImage, &p.Duration, &p.VideoUrl, &p.Description, &p.ShotCount, &createdAt, &updatedAt); err != nil {
+	var urgencyNull sql.NullString
+	if err := row.Scan(&p.ID, &p.Title, &p.StoryText, &p.Style, &p.Status, &p.CoverImage, &p.Duration, &p.VideoUrl, &p.Description, &p.ShotCount, &urgencyNull, &createdAt, &updatedAt); err != nil {
 		return p, err
 	}
+	if urgencyNull.Valid {
+		p.Urgency = urgencyNull.String
+	} else {
+		p.Urgency = ProjectUrgencyNormal
+	}
 	p.CreatedAt = createdAt
 	p.UpdatedAt = updatedAt
 	return p, nil
@@ -114,7 +112,7 @@ func CreateShot(s *Shot) error {
 }
 
 func GetShotsByProjectID(projectID string) ([]Shot, error) {
-	rows, err := DB.Query(`SELECT id, project_id, `+"`order`"+`, title, description, prompt, status, image_path, transition, created_at, updated_at FROM shot WHERE project_id = ? ORDER BY `+"`order`"+` ASC`, projectID)
+	rows, err := DB.Query(`SELECT id, project_id, `+"`order`"+`, title, description, prompt, status, image_path, transition, hls_master_path, created_at, updated_at FROM shot WHERE project_id = ? ORDER BY `+"`order`"+` ASC`, projectID)
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +121,7 @@ func GetShotsByProjectID(projectID string) ([]Shot, error) {
 	for rows.Next() {
 		var s Shot
 		var createdAt, updatedAt time.Time
-		if err := rows.Scan(&s.ID, &s.ProjectId, &s.Order, &s.Title, &s.Description, &s.Prompt, &s.Status, &s.ImagePath, &s.Transition, &createdAt, &updatedAt); err != nil {
+		if err := rows.Scan(&s.ID, &s.ProjectId, &s.Order, &s.Title, &s.Description, &s.Prompt, &s.Status, &s.ImagePath, &s.Transition, &s.HLSMasterPath, &createdAt, &updatedAt); err != nil {
 			return nil, err
 		}
 		s.CreatedAt = createdAt
@@ -150,12 +148,30 @@ func DeleteShotByID(projectID, shotID string) error {
 	return err
 }
 
-// Task create helper (简单示例)
-func CreateTask(t *Task) error {
+// applyTaskDefaults 给调用方没有显式填写的字段补上默认值，CreateTask（原生 SQL 单条插入）
+// 和 CreateProjectWithTasks/ReplaceProjectTasks（GORM 事务批量插入）共用同一份默认值逻辑，
+// 避免两条插入路径的默认值随时间推移彼此漂移。
+func applyTaskDefaults(t *Task) {
 	now := time.Now()
 	t.CreatedAt = now
 	t.UpdatedAt = now
 
+	// 调用方未指定重试策略时按任务类型给默认上限，未指定退避策略时用带抖动的指数退避
+	if t.MaxAttempts == 0 {
+		t.MaxAttempts = DefaultMaxAttempts(t.Type)
+	}
+	if t.RetryPolicy == "" {
+		t.RetryPolicy = RetryPolicyExponentialJitter
+	}
+	if t.Priority == 0 {
+		t.Priority = DefaultPriority(t.Type)
+	}
+}
+
+// Task create helper (简单示例)
+func CreateTask(t *Task) error {
+	applyTaskDefaults(t)
+
 	params, _ := json.Marshal(t.Parameters)
 	result, _ := json.Marshal(t.Result)
 
@@ -172,11 +188,17 @@ func CreateTask(t *Task) error {
 	} else {
 		finishedAtParam = t.FinishedAt
 	}
+	var nextRetryAtParam interface{}
+	if t.NextRetryAt.IsZero() {
+		nextRetryAtParam = nil
+	} else {
+		nextRetryAtParam = t.NextRetryAt
+	}
 
 	// NOTE: 不显式写入 shot_id 列（保持 NULL），INSERT 列数与占位符对齐
-	_, err := DB.Exec(`INSERT INTO task (id, project_id, type, status, progress, message, parameters, result, error, estimated_duration, started_at, finished_at, created_at, updated_at)
-        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		t.ID, t.ProjectId, t.Type, t.Status, t.Progress, t.Message, params, result, t.Error, t.EstimatedDuration, startedAtParam, finishedAtParam, t.CreatedAt, t.UpdatedAt,
+	_, err := DB.Exec(`INSERT INTO task (id, project_id, type, status, progress, message, parameters, result, error, estimated_duration, priority, attempts, max_attempts, next_retry_at, retry_policy, started_at, finished_at, created_at, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID, t.ProjectId, t.Type, t.Status, t.Progress, t.Message, params, result, t.Error, t.EstimatedDuration, t.Priority, t.Attempts, t.MaxAttempts, nextRetryAtParam, t.RetryPolicy, startedAtParam, finishedAtParam, t.CreatedAt, t.UpdatedAt,
 	)
 	return err
 }
@@ -253,6 +275,51 @@ func GetTaskByID(id string) (Task, error) {
 	return t, nil
 }
 
+// GetTasksDependingOn 返回 Parameters.DependsOn 中包含 parentTaskID 的所有任务，供
+// orchestrator 在某个任务完成/失败时扫描需要解锁或级联的子任务。用 JSON_CONTAINS 直接在 SQL
+// 里做包含匹配，避免把全表 parameters 都反序列化到内存里再比对。
+func GetTasksDependingOn(parentTaskID string) ([]Task, error) {
+	rows, err := DB.Query(`SELECT id, project_id, shot_id, type, status, progress, message, parameters, result, error, estimated_duration, started_at, finished_at, created_at, updated_at FROM task WHERE JSON_CONTAINS(parameters->'$.depends_on', JSON_QUOTE(?))`, parentTaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []Task
+	for rows.Next() {
+		var t Task
+		var paramsBytes, resultBytes []byte
+		var startedAt, finishedAt, createdAt, updatedAt sql.NullTime
+		var shotIDNull, messageNull, errorNull sql.NullString
+
+		if err := rows.Scan(&t.ID, &t.ProjectId, &shotIDNull, &t.Type, &t.Status, &t.Progress, &messageNull, &paramsBytes, &resultBytes, &errorNull, &t.EstimatedDuration, &startedAt, &finishedAt, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		if messageNull.Valid {
+			t.Message = messageNull.String
+		}
+		if errorNull.Valid {
+			t.Error = errorNull.String
+		}
+		_ = json.Unmarshal(paramsBytes, &t.Parameters)
+		_ = json.Unmarshal(resultBytes, &t.Result)
+		if startedAt.Valid {
+			t.StartedAt = startedAt.Time
+		}
+		if finishedAt.Valid {
+			t.FinishedAt = finishedAt.Time
+		}
+		if createdAt.Valid {
+			t.CreatedAt = createdAt.Time
+		}
+		if updatedAt.Valid {
+			t.UpdatedAt = updatedAt.Time
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
 // UpdateTaskStatus 更新任务的状态/进度/消息/结果等（部分字段允许为空）
 func UpdateTaskStatus(id string, status string, progress *int, message *string, result *TaskResult, errStr *string, startedAt *time.Time, finishedAt *time.Time) error {
 	// 动态构建更新字段
@@ -305,3 +372,152 @@ func UpdateTaskStatus(id string, status string, progress *int, message *string,
 	_, err := DB.Exec(query, args...)
 	return err
 }
+
+// UpdateTaskPriority 单独更新任务优先级，供 PATCH /tasks/:task_id/priority 调整在飞任务
+// 的调度顺序；只影响 FetchNextRunnableTasks 之后的排序，对已经入队到 asynq 的任务不生效。
+func UpdateTaskPriority(id string, priority int) error {
+	_, err := DB.Exec(`UPDATE task SET priority = ?, updated_at = ? WHERE id = ?`, priority, time.Now(), id)
+	return err
+}
+
+// UpdateTaskHeartbeat 供 worker 周期性调用证明任务还活着：只刷新 updated_at（可选带
+// progress/message），不碰 status——真正的完成/失败仍然走 UpdateTaskStatus。StallReaper
+// 只看 updated_at 判定是否超时，所以这里即使不传 progress/message 也必须更新一次时间戳。
+func UpdateTaskHeartbeat(id string, progress *int, message *string) error {
+	sets := []string{"updated_at = ?"}
+	args := []interface{}{time.Now()}
+	if progress != nil {
+		sets = append(sets, "progress = ?")
+		args = append(args, *progress)
+	}
+	if message != nil {
+		sets = append(sets, "message = ?")
+		args = append(args, *message)
+	}
+	args = append(args, id)
+
+	_, err := DB.Exec(fmt.Sprintf("UPDATE task SET %s WHERE id = ?", strings.Join(sets, ", ")), args...)
+	return err
+}
+
+// UpdateTasksStatusBulk 一次 UPDATE 把一批任务标成同一个 status（可选带 message），供批量
+// 取消等场景使用，避免 UpdateProject/DeleteProject 过去那种逐条 UPDATE 的写法。fromStatuses
+// 非空时只更新当前处于这些状态里的任务——调用方（尤其是直接传 ids 的场景）拿到的 id 列表不
+// 一定是刚按状态筛出来的，不加这层限制会把已经 success/blocked_by_moderation 等终态的任务
+// 也一并覆盖掉。返回受影响的行数；ids 为空时直接返回 (0, nil)，不发起查询。
+func UpdateTasksStatusBulk(ids []string, fromStatuses []string, status string, message *string) (int64, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	updates := map[string]interface{}{
+		"status":     status,
+		"updated_at": time.Now(),
+	}
+	if message != nil {
+		updates["message"] = *message
+	}
+	db := GormDB.Model(&Task{}).Where("id IN ?", ids)
+	if len(fromStatuses) > 0 {
+		db = db.Where("status IN ?", fromStatuses)
+	}
+	tx := db.Updates(updates)
+	return tx.RowsAffected, tx.Error
+}
+
+// GetTasksByProjectAndStatus 按项目+状态查询任务，供 UpdateProject 在收尾前找出仍在
+// processing 的任务（以便对外通知 worker/取消轮询），走 GormDB 而不是原生 SQL 手工 Scan。
+func GetTasksByProjectAndStatus(db *gorm.DB, projectID, status string) ([]Task, error) {
+	var tasks []Task
+	err := db.Where("project_id = ? AND status = ?", projectID, status).Find(&tasks).Error
+	return tasks, err
+}
+
+// GetTasksByIDs 按主键批量取任务，供批量取消场景在发起 worker 通知前拿到每个任务的
+// Result.ResourceId；ids 为空时直接返回空切片，不发起查询。
+func GetTasksByIDs(db *gorm.DB, ids []string) ([]Task, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var tasks []Task
+	err := db.Where("id IN ?", ids).Find(&tasks).Error
+	return tasks, err
+}
+
+// CreateProjectWithTasks 在一个事务内创建项目、文本任务、分镜任务：任意一步失败整体回滚，
+// 不会出现项目已创建但任务缺失（或反过来）的半成品状态。project/textTask/shotTasks 的 ID
+// 需由调用方预先生成好（CreateProject 里调用方已经这样做，方便事务失败前就能拿到 ID 用于
+// 日志/响应）。
+func CreateProjectWithTasks(db *gorm.DB, project *Project, textTask *Task, shotTasks []*Task) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		project.CreatedAt = now
+		project.UpdatedAt = now
+		if project.Urgency == "" {
+			project.Urgency = ProjectUrgencyNormal
+		}
+		if err := tx.Create(project).Error; err != nil {
+			return fmt.Errorf("创建项目失败: %w", err)
+		}
+
+		applyTaskDefaults(textTask)
+		if err := tx.Create(textTask).Error; err != nil {
+			return fmt.Errorf("创建文本任务失败: %w", err)
+		}
+
+		if len(shotTasks) > 0 {
+			for _, t := range shotTasks {
+				applyTaskDefaults(t)
+			}
+			if err := tx.Create(&shotTasks).Error; err != nil {
+				return fmt.Errorf("创建分镜任务失败: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ReplaceProjectTasks 在一个事务内收尾旧任务并起新任务，是 UpdateProject 的核心写入路径：
+// 先按 projectUpdates 更新项目本身（只放调用方实际要改的字段），再把 processingTaskIDs
+// （调用方已经在事务外完成了 worker 取消通知/本地轮询取消等副作用）标记为 cancelled，删除
+// 所有还没开始的 pending/blocked 任务，最后插入新的文本任务和分镜任务。任意一步出错整体
+// 回滚，避免项目卡在「旧任务已删、新任务没建」这种两头都不对的中间态。
+func ReplaceProjectTasks(db *gorm.DB, projectID string, projectUpdates map[string]interface{}, processingTaskIDs []string, cancelMessage string, textTask *Task, shotTasks []*Task) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(projectUpdates) > 0 {
+			projectUpdates["updated_at"] = time.Now()
+			if err := tx.Model(&Project{}).Where("id = ?", projectID).Updates(projectUpdates).Error; err != nil {
+				return fmt.Errorf("更新项目失败: %w", err)
+			}
+		}
+
+		if len(processingTaskIDs) > 0 {
+			if err := tx.Model(&Task{}).Where("id IN ?", processingTaskIDs).Updates(map[string]interface{}{
+				"status":     TaskStatusCancelled,
+				"message":    cancelMessage,
+				"updated_at": time.Now(),
+			}).Error; err != nil {
+				return fmt.Errorf("标记旧任务取消失败: %w", err)
+			}
+		}
+
+		if err := tx.Where("project_id = ? AND status IN ?", projectID, []string{TaskStatusPending, TaskStatusBlocked}).
+			Delete(&Task{}).Error; err != nil {
+			return fmt.Errorf("删除未开始任务失败: %w", err)
+		}
+
+		applyTaskDefaults(textTask)
+		if err := tx.Create(textTask).Error; err != nil {
+			return fmt.Errorf("创建文本任务失败: %w", err)
+		}
+
+		if len(shotTasks) > 0 {
+			for _, t := range shotTasks {
+				applyTaskDefaults(t)
+			}
+			if err := tx.Create(&shotTasks).Error; err != nil {
+				return fmt.Errorf("创建分镜任务失败: %w", err)
+			}
+		}
+		return nil
+	})
+}
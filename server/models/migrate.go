@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"StoryToVideo-server/models/migrations"
+)
+
+const schemaMigrationsDDL = `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    INT PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    applied_at DATETIME NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// ensureMigrationsTable 建 schema_migrations 本身，是迁移系统能运作的前提，每次启动都无
+// 条件确保它存在。
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(schemaMigrationsDDL)
+	return err
+}
+
+func appliedVersions(db *sql.DB) (map[int]bool, error) {
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	return applied, rows.Err()
+}
+
+// splitStatements 按 ";" 拆分一个迁移文件里的多条语句，跳过空语句和整行注释。迁移文件由
+// 我们自己维护、不含外部输入，不需要处理带引号分号的场景。
+func splitStatements(sqlText string) []string {
+	raw := strings.Split(sqlText, ";")
+	stmts := make([]string, 0, len(raw))
+	for _, s := range raw {
+		lines := strings.Split(s, "\n")
+		kept := lines[:0]
+		for _, line := range lines {
+			if strings.HasPrefix(strings.TrimSpace(line), "--") {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		s = strings.TrimSpace(strings.Join(kept, "\n"))
+		if s == "" {
+			continue
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts
+}
+
+// MigrateUp 把 schema_migrations 里缺的、且 Version <= targetVersion 的迁移按顺序逐个应
+// 用；targetVersion <= 0 表示不设上限，迁移到 embedded 目录里的最新版本。每个迁移单独开一
+// 个事务：要么整条迁移全部成功并记一行 schema_migrations，要么回滚并直接返回 error 中止启
+// 动——不再像旧的 ";"-split 逻辑那样打日志后继续，带着残缺的表结构把服务跑起来。
+//
+// 注意 MySQL 的 DDL（ALTER TABLE/CREATE TABLE）会隐式提交、不受这个事务保护：一个迁移文件
+// 如果含多条 ALTER TABLE，中间某条失败时，前面已经执行的 ALTER TABLE 早已落地，只有
+// schema_migrations 那一行 INSERT 会被回滚。下次启动会重新跑同一个文件，而前面的 ALTER
+// TABLE ADD COLUMN/ADD INDEX 这次会因为“已存在”而报错，永久卡死启动。applyMigration 对
+// ADD COLUMN/ADD INDEX 语句做了存在性检查，已生效的跳过不重新执行，使重跑同一个文件是幂等
+// 的，兜住这个半应用状态。
+func MigrateUp(ctx context.Context, targetVersion int) error {
+	if err := ensureMigrationsTable(DB); err != nil {
+		return fmt.Errorf("创建 schema_migrations 失败: %w", err)
+	}
+	all, err := migrations.Load()
+	if err != nil {
+		return err
+	}
+	applied, err := appliedVersions(DB)
+	if err != nil {
+		return fmt.Errorf("读取已应用的迁移版本失败: %w", err)
+	}
+	for _, m := range all {
+		if applied[m.Version] {
+			continue
+		}
+		if targetVersion > 0 && m.Version > targetVersion {
+			break
+		}
+		if err := applyMigration(ctx, m); err != nil {
+			return fmt.Errorf("应用迁移 %04d_%s 失败: %w", m.Version, m.Name, err)
+		}
+		log.Printf("[migrate] applied %04d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, m migrations.Migration) error {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range splitStatements(m.SQL) {
+		skip, err := alreadyApplied(ctx, tx, stmt)
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("检查语句是否已生效失败: %w ; sql: %s", err, stmt)
+		}
+		if skip {
+			log.Printf("[migrate] %04d_%s: 跳过已生效的语句: %s", m.Version, m.Name, stmt)
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("执行语句失败: %w ; sql: %s", err, stmt)
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`,
+		m.Version, m.Name, time.Now(),
+	); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+var (
+	alterAddColumnRe = regexp.MustCompile("(?i)^ALTER TABLE\\s+`?(\\w+)`?\\s+ADD COLUMN\\s+`?(\\w+)`?")
+	alterAddIndexRe  = regexp.MustCompile("(?i)^ALTER TABLE\\s+`?(\\w+)`?\\s+ADD INDEX\\s+`?(\\w+)`?")
+)
+
+// alreadyApplied 识别一条语句是否是之前（在同一迁移文件的某次半途失败重跑中）已经生效过的
+// ADD COLUMN/ADD INDEX——这两种是迁移文件里唯一会因为 DDL 隐式提交而需要重跑时跳过的语句；
+// CREATE TABLE 本身自带 IF NOT EXISTS，天然幂等，不需要特殊处理。
+func alreadyApplied(ctx context.Context, tx *sql.Tx, stmt string) (bool, error) {
+	if m := alterAddColumnRe.FindStringSubmatch(stmt); m != nil {
+		return columnExists(ctx, tx, m[1], m[2])
+	}
+	if m := alterAddIndexRe.FindStringSubmatch(stmt); m != nil {
+		return indexExists(ctx, tx, m[1], m[2])
+	}
+	return false, nil
+}
+
+func columnExists(ctx context.Context, tx *sql.Tx, table, column string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM information_schema.columns
+		 WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?`,
+		table, column,
+	).Scan(&count)
+	return count > 0, err
+}
+
+func indexExists(ctx context.Context, tx *sql.Tx, table, index string) (bool, error) {
+	var count int
+	err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM information_schema.statistics
+		 WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`,
+		table, index,
+	).Scan(&count)
+	return count > 0, err
+}
+
+// MigrateDown 删除 schema_migrations 里 Version > targetVersion 的记录，使下次 MigrateUp
+// 重新执行这些迁移。仓库里的迁移文件只含正向 DDL（没有为每个迁移配一个 down 脚本），所以这
+// 里不会反向执行任何 DROP/ALTER——它只回退记账，交给运维在手动处理好表结构/数据之后再跑
+// MigrateUp，而不是假装能安全地自动撤销一条已经跑过的 ALTER TABLE。
+func MigrateDown(ctx context.Context, targetVersion int) error {
+	if err := ensureMigrationsTable(DB); err != nil {
+		return fmt.Errorf("创建 schema_migrations 失败: %w", err)
+	}
+	_, err := DB.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version > ?`, targetVersion)
+	return err
+}
@@ -0,0 +1,62 @@
+// Package migrations 把 StoryToVideo-server 的数据库 schema 变更历史固化成一组编号的
+// SQL 文件，随二进制一起嵌入（go:embed），不再依赖运行时才能读到的 doc/sql/StoryToVideo.sql。
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// Migration 是一个按顺序应用的 schema 变更单元，Version 取自文件名前缀（0001, 0002, ...），
+// Name 是文件名去掉版本号前缀和扩展名的部分，SQL 是文件全文内容（允许含多条 ;-分隔的语句）。
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Load 读取嵌入的 *.sql 文件，解析出 Version/Name，并按 Version 升序返回，供
+// models.MigrateUp 使用。
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil, fmt.Errorf("读取迁移目录失败: %w", err)
+	}
+	result := make([]Migration, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		version, name, err := parseFilename(e.Name())
+		if err != nil {
+			return nil, err
+		}
+		content, err := files.ReadFile(e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("读取迁移文件 %s 失败: %w", e.Name(), err)
+		}
+		result = append(result, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Version < result[j].Version })
+	return result, nil
+}
+
+// parseFilename 把 "0001_init.sql" 拆成 (1, "init")
+func parseFilename(filename string) (int, string, error) {
+	base := strings.TrimSuffix(filename, ".sql")
+	parts := strings.SplitN(base, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", fmt.Errorf("迁移文件名格式错误（应为 NNNN_name.sql）: %s", filename)
+	}
+	version, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("迁移文件名版本号不是数字: %s", filename)
+	}
+	return version, parts[1], nil
+}
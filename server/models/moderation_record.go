@@ -0,0 +1,74 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 审核记录状态：waiting 提交给供应商后等待结果；passed/blocked 是回调回填的终态；
+// manual_review 是供应商判定为不确定、需要运营人工复核的中间态（不会自动 flip 资源任务）
+const (
+	ModerationStatusWaiting      = "waiting"
+	ModerationStatusPassed       = "passed"
+	ModerationStatusBlocked      = "blocked"
+	ModerationStatusManualReview = "manual_review"
+)
+
+// ModerationRecord 跟踪一次 TaskTypeModeration 任务对某个资源任务产物的异步审核：TaskID 是
+// 被审核的资源任务（generate_shot/generate_audio/generate_video），ModerationTaskID 是审核
+// 本身对应的跟进任务，两者在 Submit 时就已知；BatchID 是供应商侧批次号，由
+// POST /v1/api/moderation/callback 用来定位这条记录并回填 Status/FrameSummaries/Remarks。
+type ModerationRecord struct {
+	ID               string            `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	TaskID           string            `gorm:"index" json:"taskId"`
+	ModerationTaskID string            `json:"moderationTaskId"`
+	ShotID           string            `gorm:"index" json:"shotId,omitempty"`
+	BatchID          string            `gorm:"index" json:"batchId,omitempty"`
+	Status           string            `json:"status"`
+	FrameSummaries   StringSlice       `gorm:"type:json" json:"frameSummaries,omitempty"`
+	Remarks          ModerationRemarks `gorm:"type:json" json:"remarks,omitempty"`
+	CreatedAt        time.Time         `json:"createdAt"`
+	UpdatedAt        time.Time         `json:"updatedAt"`
+}
+
+func (ModerationRecord) TableName() string {
+	return "moderation_record"
+}
+
+// CreateModerationRecord 登记一条新的审核记录，Submit 审核请求成功后立即调用
+func CreateModerationRecord(db *gorm.DB, record *ModerationRecord) error {
+	return db.Create(record).Error
+}
+
+// GetModerationRecordByBatchID 供 POST /v1/api/moderation/callback 按供应商返回的 batch_id
+// 定位要回填的记录
+func GetModerationRecordByBatchID(db *gorm.DB, batchID string) (*ModerationRecord, error) {
+	var record ModerationRecord
+	if err := db.First(&record, "batch_id = ?", batchID).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// GetLatestModerationRecordByShotID 返回某个 shot 最近一次审核记录，供 GetShots/GetShotDetail
+// 判断资源当前是否可以展示给前端；没有记录时返回 gorm.ErrRecordNotFound，调用方视为"还没有
+// 提交过审核"。
+func GetLatestModerationRecordByShotID(db *gorm.DB, shotID string) (*ModerationRecord, error) {
+	var record ModerationRecord
+	if err := db.Where("shot_id = ?", shotID).Order("updated_at DESC").First(&record).Error; err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// UpdateModerationResult 回填供应商的审核结果，供 POST /v1/api/moderation/callback 使用
+func (r *ModerationRecord) UpdateModerationResult(db *gorm.DB, status string, frameSummaries []string, remarks ModerationRemarks) error {
+	updates := map[string]interface{}{
+		"status":          status,
+		"frame_summaries": StringSlice(frameSummaries),
+		"remarks":         remarks,
+		"updated_at":      time.Now(),
+	}
+	return db.Model(r).Updates(updates).Error
+}
@@ -0,0 +1,134 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Pipeline 节点状态：与 Task 状态是两个概念——节点描述的是 DAG 里的一个"槽位"，
+// 只有在其依赖全部 PipelineNodeStatusCompleted 后才会从 Blocked 解锁为 Queued 并创建/
+// 入队对应的 Task；节点与 Task 之间通过 TaskID 一对一绑定。
+const (
+	PipelineNodeStatusPending   = "pending"   // 尚未评估依赖（初始状态，等价于有依赖时的 blocked）
+	PipelineNodeStatusBlocked   = "blocked"   // 依赖未全部满足
+	PipelineNodeStatusQueued    = "queued"    // 依赖已满足，对应 Task 已创建并入队
+	PipelineNodeStatusRunning   = "running"   // 对应 Task 已进入 processing
+	PipelineNodeStatusCompleted = "completed"
+	PipelineNodeStatusFailed    = "failed"
+	PipelineNodeStatusCancelled = "cancelled"
+)
+
+// PipelineNode 是 DAG 中的一个节点：某个 shot（或整个项目，ShotID 为空，例如 storyboard）
+// 在某个 stage（取值同 TaskType*）上的执行单元，DependsOn 记录它依赖的其它节点 ID。
+type PipelineNode struct {
+	ID        string `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	ProjectID string `gorm:"index" json:"projectId"`
+	ShotID    string `json:"shotId,omitempty"`
+	Stage     string `json:"stage"`
+	// DependsOn 保存的是其它 PipelineNode.ID，全部到达 Completed 该节点才会被 Advance 解锁
+	DependsOn StringSlice `gorm:"type:json" json:"dependsOn,omitempty"`
+	// TaskID 是该节点解锁后创建的 Task.ID；未解锁前为空
+	TaskID string `json:"taskId,omitempty"`
+	Status string `json:"status"`
+	// Parameters 渲染该 stage Task 所需的模板变量，来自创建 pipeline 时提交的 spec
+	Parameters PipelineParameters `gorm:"type:json" json:"parameters,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+}
+
+func (PipelineNode) TableName() string {
+	return "pipeline_node"
+}
+
+// StringSlice 让 []string 可直接作为 JSON 列存取（DependsOn 等字段复用）
+type StringSlice []string
+
+func (s StringSlice) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *StringSlice) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	return json.Unmarshal(bytes, s)
+}
+
+// PipelineParameters 是任意形状的模板变量，随 pipeline spec 一起持久化
+type PipelineParameters map[string]interface{}
+
+func (p PipelineParameters) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+func (p *PipelineParameters) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	return json.Unmarshal(bytes, p)
+}
+
+// CreatePipelineNodes 批量创建 DAG 节点
+func CreatePipelineNodes(db *gorm.DB, nodes []PipelineNode) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	return db.Create(&nodes).Error
+}
+
+// GetPipelineNodesByProject 按项目加载整个 DAG，供 GET /v1/projects/:id/pipeline 渲染
+func GetPipelineNodesByProject(db *gorm.DB, projectID string) ([]PipelineNode, error) {
+	var nodes []PipelineNode
+	err := db.Where("project_id = ?", projectID).Order("created_at ASC").Find(&nodes).Error
+	return nodes, err
+}
+
+// GetPipelineNodeByTaskID 按绑定的 Task.ID 反查节点，HandleGenerateTask 据此推进 DAG
+func GetPipelineNodeByTaskID(db *gorm.DB, taskID string) (*PipelineNode, error) {
+	var node PipelineNode
+	if err := db.Where("task_id = ?", taskID).First(&node).Error; err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// GetPipelineNodesDependingOn 返回 DependsOn 中包含 nodeID 的节点（JSON 包含匹配，MySQL 下
+// 退化为全表扫描同项目节点后在内存里过滤，DAG 规模很小，足够用）
+func GetPipelineNodesDependingOn(db *gorm.DB, projectID, nodeID string) ([]PipelineNode, error) {
+	all, err := GetPipelineNodesByProject(db, projectID)
+	if err != nil {
+		return nil, err
+	}
+	var dependents []PipelineNode
+	for _, n := range all {
+		for _, dep := range n.DependsOn {
+			if dep == nodeID {
+				dependents = append(dependents, n)
+				break
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// UpdatePipelineNodeStatus 更新节点状态，绑定 Task（解锁入队时）用 taskID 非空调用一次即可
+func UpdatePipelineNodeStatus(db *gorm.DB, nodeID, status, taskID string) error {
+	updates := map[string]interface{}{"status": status, "updated_at": time.Now()}
+	if taskID != "" {
+		updates["task_id"] = taskID
+	}
+	return db.Model(&PipelineNode{}).Where("id = ?", nodeID).Updates(updates).Error
+}
@@ -14,6 +14,14 @@ const (
 	ProjectStatusFailed          = "failed"           // 项目生成过程出错
 )
 
+// 项目级紧急度，用于在没有显式 task priority 时从项目层面整体调高/调低它名下所有任务的
+// 调度优先级，见 PriorityOffsetForUrgency
+const (
+	ProjectUrgencyHigh   = "high"
+	ProjectUrgencyNormal = "normal"
+	ProjectUrgencyLow    = "low"
+)
+
 type Project struct {
     ID         string    `gorm:"primaryKey;type:varchar(64)" json:"id"`
     Title      string    `json:"title"`
@@ -25,6 +33,9 @@ type Project struct {
     VideoUrl   string    `json:"videoUrl"`
     Description string   `json:"description"`
     ShotCount  int       `json:"shotCount"`
+    // Urgency 是 ProjectUrgency* 常量之一，默认 "normal"；赶工的项目设为 "high"
+    // 后，它名下新建任务的 priority 会在 DefaultPriority 基础上调低（更早调度）
+    Urgency    string    `json:"urgency"`
     CreatedAt  time.Time `json:"createdAt"`
     UpdatedAt  time.Time `json:"updatedAt"`
 }
@@ -32,3 +43,29 @@ type Project struct {
 func (Project) TableName() string {
     return "project"
 }
+
+// PriorityOffsetForUrgency 把项目级 urgency 换算成叠加在 DefaultPriority 上的偏移量：
+// high 调低 5（更早调度），low 调高 5（更晚调度），normal/未知值不调整。
+func PriorityOffsetForUrgency(urgency string) int {
+	switch urgency {
+	case ProjectUrgencyHigh:
+		return -5
+	case ProjectUrgencyLow:
+		return 5
+	default:
+		return 0
+	}
+}
+
+// TaskPriorityForProject 结合任务类型的默认优先级和项目紧急度算出具体任务应使用的
+// priority；若调用方显式指定了 explicitPriority（> 0）则直接使用，不再按 urgency 调整。
+func TaskPriorityForProject(taskType, urgency string, explicitPriority int) int {
+	if explicitPriority > 0 {
+		return explicitPriority
+	}
+	priority := DefaultPriority(taskType) + PriorityOffsetForUrgency(urgency)
+	if priority < 1 {
+		priority = 1
+	}
+	return priority
+}
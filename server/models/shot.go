@@ -1,6 +1,10 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -11,21 +15,83 @@ const (
 	ShotStatusProcessing = "processing"
 	ShotStatusCompleted  = "completed"
 	ShotStatusFailed     = "failed"
+	// ShotStatusRejected: 分镜的某个产物（描述/TTS文案/图片/视频）未通过内容审核
+	ShotStatusRejected = "rejected"
+	// ShotStatusStreamReady: 成片视频已完成 HLS 切片打包，可通过 stream.m3u8 播放
+	ShotStatusStreamReady = "stream_ready"
 )
 
+// ModerationRemark 记录审核命中的单条类目详情
+type ModerationRemark struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+	Detail   string  `json:"detail,omitempty"`
+}
+
+// ModerationScores 保存各审核类目的命中分数，供运营/前端展示
+type ModerationScores struct {
+	Porn      float64 `json:"porn"`
+	Violence  float64 `json:"violence"`
+	Political float64 `json:"political"`
+	Other     float64 `json:"other,omitempty"`
+}
+
 type Shot struct {
-    ID          string    `gorm:"primaryKey;type:varchar(64)" json:"id"`
-    ProjectId   string    `json:"projectId"`
-    Order       int       `json:"order"`
-    Title       string    `json:"title"`
-    Description string    `json:"description"`
-    Prompt      string    `json:"prompt"`
-    Status      string    `json:"status"`
-    ImagePath   string    `json:"imagePath"`
-    AudioPath   string    `json:"audioPath"`
-    Transition  string    `json:"transition"`
-    CreatedAt   time.Time `json:"createdAt"`
-    UpdatedAt   time.Time `json:"updatedAt"`
+	ID          string `gorm:"primaryKey;type:varchar(64)" json:"id"`
+	ProjectId   string `json:"projectId"`
+	Order       int    `json:"order"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Prompt      string `json:"prompt"`
+	Status      string `json:"status"`
+	ImagePath   string `json:"imagePath"`
+	AudioPath   string `json:"audioPath"`
+	Transition  string `json:"transition"`
+	// Image/Audio/VideoSha256 是对应产物内容的 sha256 十六进制摘要（service.UploadToMinIOResumable
+	// 流式计算），预签名 URL 每 72h 轮换而这个值不变，供前端拼 cache-busting 参数用
+	ImageSha256 string `json:"imageSha256,omitempty"`
+	AudioSha256 string `json:"audioSha256,omitempty"`
+	VideoSha256 string `json:"videoSha256,omitempty"`
+	// Remarks/ModerationScores 仅在 Status == ShotStatusRejected 时有意义
+	Remarks          ModerationRemarks `gorm:"type:json" json:"remarks,omitempty"`
+	ModerationScores ModerationScores  `gorm:"type:json" json:"moderationScores,omitempty"`
+	// HLSMasterPath 是 master.m3u8 在 MinIO 中的对象路径（非预签名 URL），仅在打包完成后非空
+	HLSMasterPath string    `json:"hlsMasterPath,omitempty"`
+	CreatedAt     time.Time `json:"createdAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// ModerationRemarks 实现 driver.Valuer/sql.Scanner，使 []ModerationRemark 可直接作为 JSON 列存取
+type ModerationRemarks []ModerationRemark
+
+func (r ModerationRemarks) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *ModerationRemarks) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	return json.Unmarshal(bytes, r)
+}
+
+func (r ModerationScores) Value() (driver.Value, error) {
+	return json.Marshal(r)
+}
+
+func (r *ModerationScores) Scan(value interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("Failed to unmarshal JSONB value:", value))
+	}
+	return json.Unmarshal(bytes, r)
 }
 
 func BatchCreateShots(db *gorm.DB, shots []Shot) error {
@@ -35,23 +101,35 @@ func BatchCreateShots(db *gorm.DB, shots []Shot) error {
 	return db.Create(&shots).Error
 }
 
-func (s *Shot) UpdateImage(db *gorm.DB, imagePath string) error {
+func (s *Shot) UpdateImage(db *gorm.DB, imagePath, sha256Hex string) error {
 	updates := map[string]interface{}{
-		"image_path": imagePath,
-		"status":     ShotStatusCompleted,
-		"updated_at": time.Now(),
+		"image_path":   imagePath,
+		"image_sha256": sha256Hex,
+		"status":       ShotStatusCompleted,
+		"updated_at":   time.Now(),
+	}
+	return db.Model(s).Updates(updates).Error
+}
+
+// RejectForModeration 将分镜标记为未通过内容审核，并记录命中的类目/分数，供运营后台排查
+func (s *Shot) RejectForModeration(db *gorm.DB, remarks ModerationRemarks, scores ModerationScores) error {
+	updates := map[string]interface{}{
+		"status":            ShotStatusRejected,
+		"remarks":           remarks,
+		"moderation_scores": scores,
+		"updated_at":        time.Now(),
 	}
 	return db.Model(s).Updates(updates).Error
 }
 
 func GetShotByIDGorm(db *gorm.DB, shotID string) (*Shot, error) {
-    var shot Shot
-    if err := db.First(&shot, "id = ?", shotID).Error; err != nil {
-        return nil, err
-    }
-    return &shot, nil
+	var shot Shot
+	if err := db.First(&shot, "id = ?", shotID).Error; err != nil {
+		return nil, err
+	}
+	return &shot, nil
 }
 
 func (Shot) TableName() string {
-    return "shot"
+	return "shot"
 }
@@ -7,6 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -24,12 +26,32 @@ const (
 	TaskStatusFailed     = "failed"
 	// cancelled: 任务被用户/系统取消（例如项目更新时取消正在 processing 的任务）
 	TaskStatusCancelled = "cancelled"
+	// retry_scheduled: 任务失败但还有重试次数，等 next_retry_at 到期后由 RetrySweeper 重新入队
+	TaskStatusRetryScheduled = "retry_scheduled"
+	// blocked_by_moderation: 资源任务已经完成生成，但产物未通过内容审核（ModerationRecord.Status
+	// == blocked），资源已从 MinIO 删除，对前端/GetShots 不再可见
+	TaskStatusBlockedByModeration = "blocked_by_moderation"
 
 	// 定义三种核心任务类型
 	TaskTypeStoryboard   = "generate_storyboard" // 文本 -> 分镜脚本
 	TaskTypeShotImage    = "generate_shot"       // 关键帧 -> 生图
 	TaskTypeProjectAudio = "generate_audio"      // 文本 -> 旁白语音
 	TaskTypeVideoGen     = "generate_video"      // (可选) 图 -> 视频
+	// TaskTypeModeration 是 generate_shot/generate_audio/generate_video 完成后自动创建、
+	// 依赖它们的跟进任务，提交产物给 service/moderation.Moderator 审核，见 models.ModerationRecord
+	TaskTypeModeration = "moderate_content"
+)
+
+// 重试退避策略，配合 computeNextRetryAt 使用
+const (
+	RetryPolicyFixed             = "fixed"              // 每次都按 retryBaseDelay 固定等待
+	RetryPolicyExponential       = "exponential"        // base * 2^attempts，封顶 retryMaxDelay
+	RetryPolicyExponentialJitter = "exponential_jitter" // 指数增长基础上加 ±20% 抖动，避免惊群
+)
+
+const (
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 10 * time.Minute
 )
 
 type Task struct {
@@ -44,10 +66,19 @@ type Task struct {
 	Result            TaskResult     `gorm:"type:json" json:"result"`
 	Error             string         `json:"error"`
 	EstimatedDuration int            `json:"estimatedDuration"`
-	StartedAt         time.Time      `json:"startedAt"`
-	FinishedAt        time.Time      `json:"finishedAt"`
-	CreatedAt         time.Time      `json:"createdAt"`
-	UpdatedAt         time.Time      `json:"updatedAt"`
+	// Priority 数值越小优先级越高，调度时既用于 asynq 的优先级队列分桶，也用于
+	// FetchNextRunnableTasks 的排序；为 0（未指定）时由 DefaultPriority 按 Type 给出默认值。
+	Priority int `json:"priority"`
+	// Attempts 是已经尝试过的次数（每次失败重试 +1），MaxAttempts 达到上限后失败就不再重试，
+	// 直接落到 TaskStatusFailed；两者都为 0 时由 DefaultMaxAttempts 按 Type 给出默认值。
+	Attempts    int       `json:"attempts"`
+	MaxAttempts int       `json:"maxAttempts"`
+	NextRetryAt time.Time `json:"nextRetryAt,omitempty"`
+	RetryPolicy string    `json:"retryPolicy,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	FinishedAt  time.Time `json:"finishedAt"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
 type TaskParameters struct {
@@ -56,6 +87,10 @@ type TaskParameters struct {
 	Video        *VideoParams        `json:"video,omitempty"`
 	TTS          *TTSParams          `json:"tts,omitempty"`
 	DependsOn    []string            `json:"depends_on,omitempty"`
+	// BatchID 非空表示这个 Task 是某次 service.SubmitSharded* 拆出的一个分片，对应 Redis 里
+	// batch:<BatchID> 这个 BatchTCB；ShardIndex 是它在该批次里的序号，仅用于展示/排错。
+	BatchID    string `json:"batch_id,omitempty"`
+	ShardIndex int    `json:"shard_index,omitempty"`
 }
 
 type ShotDefaultsParams struct {
@@ -91,9 +126,13 @@ type TTSParams struct {
 
 // TaskResult 仅保留最小资源定位信息
 type TaskResult struct {
-	ResourceType string                 `json:"resource_type"` // e.g., "image", "audio", "json"
-	ResourceId   string                 `json:"resource_id"`
-	ResourceUrl  string                 `json:"resource_url"`
+	ResourceType string `json:"resource_type"` // e.g., "image", "audio", "json"
+	ResourceId   string `json:"resource_id"`
+	ResourceUrl  string `json:"resource_url"`
+	// PartialResults 只有分片批次任务失败/超时才会非空：收集已经跑完的分片的 ResourceUrl，
+	// 供 POST /v1/api/tasks/:task_id/retry_failed_shards 让调用方只重试缺的那些分片，而不用
+	// 把整个父任务推倒重来。
+	PartialResults []string `json:"partial_results,omitempty"`
 }
 
 // 实现 driver.Valuer 接口: Go Struct -> JSON String (存入数据库)
@@ -163,9 +202,350 @@ func (t *Task) UpdateStatus(db *gorm.DB, status string, result interface{}, errM
 	if errMsg != "" {
 		updates["error"] = errMsg
 	}
+
+	// 失败且还有重试次数时，不落到终态 failed，而是改为 retry_scheduled 等 RetrySweeper 捡回去
+	if status == TaskStatusFailed && errMsg != "" {
+		for k, v := range t.failureUpdates(errMsg) {
+			updates[k] = v
+		}
+	}
 	return db.Model(t).Updates(updates).Error
 }
 
+// failureUpdates 根据 t 当前的 attempts/max_attempts 决定这次失败应该是重新排队重试，
+// 还是落到终态 failed；MaxAttempts 为 0 时（历史任务或调用方未指定）按 Type 取默认值。
+func (t *Task) failureUpdates(errMsg string) map[string]interface{} {
+	maxAttempts := t.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = DefaultMaxAttempts(t.Type)
+	}
+	if t.Attempts < maxAttempts {
+		return map[string]interface{}{
+			"status":        TaskStatusRetryScheduled,
+			"attempts":      t.Attempts + 1,
+			"max_attempts":  maxAttempts,
+			"next_retry_at": computeNextRetryAt(t.RetryPolicy, t.Attempts),
+			"error":         errMsg,
+		}
+	}
+	return map[string]interface{}{
+		"status":       TaskStatusFailed,
+		"max_attempts": maxAttempts,
+		"error":        errMsg,
+	}
+}
+
+// ResolveTaskFailure 供没有现成 *Task 的调用方使用：载入 task 后委托
+// failureUpdates 做同样的重试判断，返回最终落地的状态（retry_scheduled 或 failed）供调用方
+// 广播。和 Task.UpdateStatus 一样是 load-then-write，不做事务包裹，这与仓库里其它基于
+// GetTaskByIDGorm + Updates 的调用方式一致。
+func ResolveTaskFailure(db *gorm.DB, taskID, errMsg string) (string, error) {
+	task, err := GetTaskByIDGorm(db, taskID)
+	if err != nil {
+		return "", err
+	}
+	updates := task.failureUpdates(errMsg)
+	updates["updated_at"] = time.Now()
+	if err := db.Model(task).Updates(updates).Error; err != nil {
+		return "", err
+	}
+	return updates["status"].(string), nil
+}
+
+// computeNextRetryAt 按 policy 计算下一次重试时间：fixed 恒定 retryBaseDelay；exponential
+// 按 base * 2^attempt 增长；exponential_jitter 在此基础上加 ±20% 抖动，避免同一批失败任务
+// 同时重试造成惊群。结果都封顶在 retryMaxDelay。未知/空 policy 按 exponential_jitter 处理。
+func computeNextRetryAt(policy string, attempt int) time.Time {
+	delay := retryBaseDelay * time.Duration(uint(1)<<uint(attempt))
+	if delay > retryMaxDelay || delay < 0 {
+		delay = retryMaxDelay
+	}
+	if policy == RetryPolicyFixed {
+		delay = retryBaseDelay
+	} else if policy == RetryPolicyExponentialJitter || policy == RetryPolicyExponential || policy == "" {
+		if policy != RetryPolicyExponential {
+			jitter := float64(delay) * 0.2
+			offset := time.Duration((rand.Float64()*2 - 1) * jitter)
+			delay += offset
+			if delay < 0 {
+				delay = 0
+			}
+			if delay > retryMaxDelay {
+				delay = retryMaxDelay
+			}
+		}
+	}
+	return time.Now().Add(delay)
+}
+
+// DefaultMaxAttempts 在调用方（CreateProject/UpdateProject 等）没有显式指定 max_attempts
+// 时给出按任务类型区分的默认重试次数：storyboard 依赖单次 LLM 调用，重试意义有限给 2 次；
+// 生图/生视频/TTS 更容易因为上游模型服务抖动而失败，给更宽松的次数避免拖垮整个项目。
+func DefaultMaxAttempts(taskType string) int {
+	switch taskType {
+	case TaskTypeStoryboard:
+		return 2
+	case TaskTypeShotImage:
+		return 5
+	case TaskTypeProjectAudio, TaskTypeVideoGen:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// GetDueRetryTasks 返回所有处于 retry_scheduled 且 next_retry_at 已过期的任务，供
+// RetrySweeper 周期性扫描后重新入队。
+func GetDueRetryTasks(db *gorm.DB, now time.Time) ([]Task, error) {
+	var tasks []Task
+	err := db.Where("status = ? AND next_retry_at <= ?", TaskStatusRetryScheduled, now).Find(&tasks).Error
+	return tasks, err
+}
+
+// GetProcessingTasks 返回所有处于 processing 状态的任务，供 StallReaper 周期性扫描心跳
+// （updated_at）是否超过阈值没有更新。
+func GetProcessingTasks(db *gorm.DB) ([]Task, error) {
+	var tasks []Task
+	err := db.Where("status = ?", TaskStatusProcessing).Find(&tasks).Error
+	return tasks, err
+}
+
+// DefaultPriority 在调用方（CreateProject/UpdateProject 等）没有显式指定 priority 时给出
+// 按任务类型区分的默认优先级（数值越小越先调度）：故事文本是后续所有分镜任务的前置依赖，
+// 优先级最高；配音比生图快、生视频最慢最占资源，故排在最后。
+func DefaultPriority(taskType string) int {
+	switch taskType {
+	case TaskTypeStoryboard:
+		return 10
+	case TaskTypeShotImage:
+		return 20
+	case TaskTypeProjectAudio:
+		return 25
+	case TaskTypeVideoGen:
+		return 30
+	default:
+		return 20
+	}
+}
+
+// DefaultTypeConcurrency 在 config.AppConfig.Scheduler.TypeConcurrency 没有为某个类型配置
+// 并发上限时兜底：生视频最吃显存/时间，单独限流到 1 路；生图相对轻量，放宽到 4 路。0 表示
+// 不限制。
+func DefaultTypeConcurrency(taskType string) int {
+	switch taskType {
+	case TaskTypeVideoGen:
+		return 1
+	case TaskTypeShotImage:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// CountTasksByStatusAndType 统计某状态+类型的任务数，供 EnqueueTask 在真正入队前做一次
+// 单类型的准入检查（FetchNextRunnableTasks 批量调度时则一次性按 GROUP BY 查所有类型）。
+func CountTasksByStatusAndType(db *gorm.DB, status, taskType string) (int64, error) {
+	var count int64
+	err := db.Model(&Task{}).Where("status = ? AND type = ?", status, taskType).Count(&count).Error
+	return count, err
+}
+
+// typeConcurrencyLimit 返回某个类型的并发上限：typeQuotas 里显式配置的优先，否则兜底到
+// DefaultTypeConcurrency；0 表示不限制。
+func typeConcurrencyLimit(taskType string, typeQuotas map[string]int) int {
+	if quota, ok := typeQuotas[taskType]; ok {
+		return quota
+	}
+	return DefaultTypeConcurrency(taskType)
+}
+
+// dependenciesSatisfied 报告 depIDs 里引用的 Task 是否都已经是 TaskStatusSuccess；depIDs
+// 为空视为已满足。用于 FetchNextRunnableTasks 对 TaskParameters.DependsOn 的校验。
+// DependenciesSatisfied 是 dependenciesSatisfied 的导出版本，供 service/orchestrator 复用同
+// 一套"依赖是否都已 finished"判断逻辑，避免在两处各写一份。
+func DependenciesSatisfied(db *gorm.DB, depIDs []string) (bool, error) {
+	return dependenciesSatisfied(db, depIDs)
+}
+
+func dependenciesSatisfied(db *gorm.DB, depIDs []string) (bool, error) {
+	for _, id := range depIDs {
+		var dep Task
+		if err := db.Select("status").Where("id = ?", id).First(&dep).Error; err != nil {
+			return false, err
+		}
+		if dep.Status != TaskStatusSuccess {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// FetchNextRunnableTasks 按 (priority ASC, created_at ASC) 取出最多 limit 个当前可以运行的
+// pending 任务：TaskParameters.DependsOn 引用的任务必须已全部完成，且同类型当前 processing
+// 数量未超过 typeQuotas（未显式配置的类型按 DefaultTypeConcurrency 兜底）。用于给调度器挑选
+// 下一批入队的任务，保证单个项目刷一堆同类型任务时不会饿死其它项目——相当于一个按类型分桶的
+// 准入控制，而不是谁先创建谁先跑。
+func FetchNextRunnableTasks(db *gorm.DB, limit int, typeQuotas map[string]int) ([]Task, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	var processingCounts []struct {
+		Type  string
+		Count int
+	}
+	if err := db.Model(&Task{}).
+		Select("type, count(*) as count").
+		Where("status = ?", TaskStatusProcessing).
+		Group("type").
+		Scan(&processingCounts).Error; err != nil {
+		return nil, err
+	}
+	inFlight := make(map[string]int, len(processingCounts))
+	for _, c := range processingCounts {
+		inFlight[c.Type] = c.Count
+	}
+
+	var candidates []Task
+	if err := db.Where("status = ?", TaskStatusPending).
+		Order("priority ASC, created_at ASC").
+		Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	runnable := make([]Task, 0, limit)
+	for _, t := range candidates {
+		if len(runnable) >= limit {
+			break
+		}
+		if quota := typeConcurrencyLimit(t.Type, typeQuotas); quota > 0 && inFlight[t.Type] >= quota {
+			continue
+		}
+		ok, err := dependenciesSatisfied(db, t.Parameters.DependsOn)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		runnable = append(runnable, t)
+		inFlight[t.Type]++
+	}
+	return runnable, nil
+}
+
+const (
+	DefaultTaskPageSize = 20
+	MaxTaskPageSize     = 200
+)
+
+// taskSortColumns 是 TaskListFilter.Sort 允许映射到的列白名单，防止把未经校验的字符串
+// 直接拼进 ORDER BY 造成注入。
+var taskSortColumns = map[string]string{
+	"status":     "status",
+	"type":       "type",
+	"progress":   "progress",
+	"created_at": "created_at",
+	"updated_at": "updated_at",
+}
+
+// TaskListFilter 描述 ListTasks 支持的过滤/分页/排序条件。Page 从 1 开始，Page/PageSize
+// 非法或为 0 时分别回落到 1 / DefaultTaskPageSize。Sort 形如 "status:asc,created_at:desc"，
+// 未知字段会被直接忽略。
+type TaskListFilter struct {
+	ProjectID     string
+	ShotID        string
+	Types         []string
+	Statuses      []string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Keyword       string
+	Page          int
+	PageSize      int
+	Sort          string
+}
+
+// ListTasks 按条件分页查询任务，返回当前页数据和满足条件的总数（不含分页）。既用于管理
+// 后台排查卡住的 DAG，也用于 RetrySweeper/依赖解锁器之类的后台扫描分批取候选任务，而不用
+// 一次性把整张表加载进内存。
+func ListTasks(db *gorm.DB, filter TaskListFilter) ([]Task, int64, error) {
+	query := db.Model(&Task{})
+
+	if filter.ProjectID != "" {
+		query = query.Where("project_id = ?", filter.ProjectID)
+	}
+	if filter.ShotID != "" {
+		query = query.Where("shot_id = ?", filter.ShotID)
+	}
+	if len(filter.Types) > 0 {
+		query = query.Where("type IN ?", filter.Types)
+	}
+	if len(filter.Statuses) > 0 {
+		query = query.Where("status IN ?", filter.Statuses)
+	}
+	if filter.CreatedAfter != nil {
+		query = query.Where("created_at >= ?", *filter.CreatedAfter)
+	}
+	if filter.CreatedBefore != nil {
+		query = query.Where("created_at <= ?", *filter.CreatedBefore)
+	}
+	if filter.Keyword != "" {
+		like := "%" + filter.Keyword + "%"
+		query = query.Where("message LIKE ? OR error LIKE ?", like, like)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	page := filter.Page
+	if page < 1 {
+		page = 1
+	}
+	pageSize := filter.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultTaskPageSize
+	}
+	if pageSize > MaxTaskPageSize {
+		pageSize = MaxTaskPageSize
+	}
+
+	var tasks []Task
+	err := query.Order(buildTaskOrderClause(filter.Sort)).
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&tasks).Error
+	return tasks, total, err
+}
+
+// buildTaskOrderClause 把 "status:asc,created_at:desc" 这样的 sort 参数转换为 ORDER BY
+// 子句，只接受 taskSortColumns 白名单内的字段；sort 为空或全部无法识别时回落到
+// "created_at DESC"。
+func buildTaskOrderClause(sort string) string {
+	clauses := make([]string, 0, 4)
+	for _, part := range strings.Split(sort, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fieldDir := strings.SplitN(part, ":", 2)
+		column, ok := taskSortColumns[strings.TrimSpace(fieldDir[0])]
+		if !ok {
+			continue
+		}
+		dir := "ASC"
+		if len(fieldDir) == 2 && strings.EqualFold(strings.TrimSpace(fieldDir[1]), "desc") {
+			dir = "DESC"
+		}
+		clauses = append(clauses, column+" "+dir)
+	}
+	if len(clauses) == 0 {
+		return "created_at DESC"
+	}
+	return strings.Join(clauses, ", ")
+}
+
 func GetTaskByIDGorm(db *gorm.DB, taskID string) (*Task, error) {
 	var task Task
 	if err := db.First(&task, "id = ?", taskID).Error; err != nil {
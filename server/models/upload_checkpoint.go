@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UploadCheckpoint 记录一次分片上传中已经成功提交的分片，key 为 (task_id, object_name, part_number)。
+// 进程重启后，service.UploadToMinIOResumable 通过它 + MinIO 的 ListParts 结果对账，
+// 从最后一个已提交的分片继续，而不用重新上传整份文件。
+type UploadCheckpoint struct {
+	TaskID     string    `gorm:"primaryKey;type:varchar(64)" json:"taskId"`
+	ObjectName string    `gorm:"primaryKey;type:varchar(512)" json:"objectName"`
+	PartNumber int       `gorm:"primaryKey" json:"partNumber"`
+	UploadID   string    `json:"uploadId"`
+	ETag       string    `json:"etag"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+func (UploadCheckpoint) TableName() string {
+	return "upload_checkpoint"
+}
+
+// UpsertUploadCheckpoint 落盘一个已完成分片的 PartNumber+ETag，主键冲突时覆盖（用于重试后更新）
+func UpsertUploadCheckpoint(db *gorm.DB, cp *UploadCheckpoint) error {
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now()
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "task_id"}, {Name: "object_name"}, {Name: "part_number"}},
+		DoUpdates: clause.AssignmentColumns([]string{"upload_id", "etag", "size"}),
+	}).Create(cp).Error
+}
+
+// ListUploadCheckpoints 返回某个 (taskID, objectName) 已落盘的分片记录
+func ListUploadCheckpoints(db *gorm.DB, taskID, objectName string) ([]UploadCheckpoint, error) {
+	var checkpoints []UploadCheckpoint
+	err := db.Where("task_id = ? AND object_name = ?", taskID, objectName).
+		Order("part_number ASC").Find(&checkpoints).Error
+	return checkpoints, err
+}
+
+// DeleteUploadCheckpoints 在 multipart upload 完成或被中止后清理残留的检查点行
+func DeleteUploadCheckpoints(db *gorm.DB, taskID, objectName string) error {
+	return db.Where("task_id = ? AND object_name = ?", taskID, objectName).Delete(&UploadCheckpoint{}).Error
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"StoryToVideo-server/config"
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service"
+	"StoryToVideo-server/service/moderation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// verifyModerationSignature 校验请求头 X-Signature 是否等于 hex(HMAC-SHA256(body, CallbackSecret))。
+// CallbackSecret 未配置时（本地开发/mock 供应商）直接放行，不强制要求签名。
+func verifyModerationSignature(body []byte, signature string) bool {
+	secret := ""
+	if config.AppConfig != nil {
+		secret = config.AppConfig.Moderation.CallbackSecret
+	}
+	if secret == "" {
+		return true
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// ModerationCallback 接收审核供应商的异步回调：POST /v1/api/moderation/callback
+// 用 X-Signature 头（HMAC-SHA256(body, Moderation.CallbackSecret) 的 hex）校验来源。
+//
+// 兼容两种批次：
+//   - 旧的逐帧批次（视频采样帧审核，BatchTaskControlBlock）：
+//     Body: {"batch_id","frame_id","passed","scores","remarks"}，到齐后按结果把 Shot 标记为
+//     Completed 或 Rejected。processor.go 已经不再提交这类批次（统一走下面这条单资源路径，
+//     避免同一份产物被审核供应商收两次），这里继续保留兼容是为了不破坏仍在使用旧 webhook
+//     格式的供应商配置。
+//   - chunk2-5 新增的单资源批次（TaskTypeModeration 跟进任务，models.ModerationRecord）：
+//     Body 不带 frame_id，直接把该 BatchID 对应的审核记录回填为终态，推进审核任务。这是目前
+//     图片/音频/视频产物审核唯一的提交入口，见 service.SubmitModerationFollowUp。
+func ModerationCallback(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "读取请求体失败: " + err.Error()})
+		return
+	}
+	if !verifyModerationSignature(body, c.GetHeader("X-Signature")) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "签名校验失败"})
+		return
+	}
+
+	var req struct {
+		BatchID string                    `json:"batch_id"`
+		FrameID string                    `json:"frame_id"`
+		Passed  bool                      `json:"passed"`
+		Status  string                    `json:"status"` // passed | blocked | manual_review，frame_id 为空时使用
+		Scores  moderation.CategoryScores `json:"scores"`
+		Remarks []moderation.Remark       `json:"remarks"`
+	}
+	// 签名校验已经把 body 读空了（c.Request.Body 是一次性的 io.Reader），改用已经读到手的
+	// body 字节反序列化，不再用会再读一次 body 的 c.ShouldBindJSON
+	if err := json.Unmarshal(body, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.BatchID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "batch_id 不能为空"})
+		return
+	}
+
+	if req.FrameID == "" {
+		handleAssetModerationCallback(c, req.BatchID, req.Status, req.Passed, req.Remarks)
+		return
+	}
+
+	result := &moderation.Result{Passed: req.Passed, Scores: req.Scores, Remarks: req.Remarks}
+	btcb, done, err := moderation.SubmitFrameResult(req.BatchID, req.FrameID, result)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !done {
+		c.JSON(http.StatusOK, gin.H{"batch_id": req.BatchID, "arrived": btcb.Arrived, "total": btcb.Total})
+		return
+	}
+	defer moderation.DefaultManager.Complete(req.BatchID)
+
+	if btcb.ShotID == "" {
+		// 批次没有关联具体 shot（例如仅做统计用途），到齐即结束
+		c.JSON(http.StatusOK, gin.H{"batch_id": req.BatchID, "done": true})
+		return
+	}
+
+	shot, err := models.GetShotByIDGorm(models.GormDB, btcb.ShotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "shot not found: " + err.Error()})
+		return
+	}
+
+	if remarks := btcb.Rejected(); len(remarks) > 0 {
+		out := make(models.ModerationRemarks, 0, len(remarks))
+		for _, r := range remarks {
+			out = append(out, models.ModerationRemark{Category: r.Category, Score: r.Score, Detail: r.Detail})
+		}
+		if err := shot.RejectForModeration(models.GormDB, out, models.ModerationScores{}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "写入拒绝原因失败: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"batch_id": req.BatchID, "shot_id": btcb.ShotID, "status": models.ShotStatusRejected})
+		return
+	}
+
+	// shot 表没有 video_url 列（成片 URL 按 shots/<shotID>/video.mp4 的固定 object key 现取现签，
+	// 见 service.processResourceToMinIO/oss.go），这里只需要把状态推进到 completed
+	updates := map[string]interface{}{"status": models.ShotStatusCompleted, "updated_at": time.Now()}
+	if err := models.GormDB.Model(shot).Updates(updates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新分镜状态失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"batch_id": req.BatchID, "shot_id": btcb.ShotID, "status": models.ShotStatusCompleted})
+}
+
+// handleAssetModerationCallback 回填 chunk2-5 新增的单资源审核批次：按 BatchID 定位
+// models.ModerationRecord，写入终态结果，再交给 service.ApplyModerationResult 推进/拦截对应的
+// 资源任务。Status 未显式传入时按 Passed 换算为 passed/blocked。
+func handleAssetModerationCallback(c *gin.Context, batchID, status string, passed bool, remarks []moderation.Remark) {
+	record, err := models.GetModerationRecordByBatchID(models.GormDB, batchID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "未找到对应的审核记录: " + err.Error()})
+		return
+	}
+
+	if status == "" {
+		if passed {
+			status = models.ModerationStatusPassed
+		} else {
+			status = models.ModerationStatusBlocked
+		}
+	}
+	out := make(models.ModerationRemarks, 0, len(remarks))
+	for _, r := range remarks {
+		out = append(out, models.ModerationRemark{Category: r.Category, Score: r.Score, Detail: r.Detail})
+	}
+	if err := record.UpdateModerationResult(models.GormDB, status, nil, out); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "写入审核结果失败: " + err.Error()})
+		return
+	}
+
+	if err := service.ApplyModerationResult(models.GormDB, record, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "推进审核任务失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"batch_id": batchID, "status": status})
+}
@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service"
+	"StoryToVideo-server/service/pipeline"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreatePipeline 接受声明式 pipeline spec 并物化成 PipelineNode DAG：没有依赖（或依赖已
+// 满足）的节点立即创建/入队对应 Task，其余节点随着上游节点完成由 HandleGenerateTask
+// 自动解锁。POST /v1/api/projects/:project_id/pipeline
+// Body: {"stages": [{"stage","depends_on","parameters","concurrency","max_retry"}, ...]}
+func CreatePipeline(c *gin.Context) {
+	projectID := c.Param("project_id")
+	if _, err := models.GetProjectByID(projectID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "project not found: " + err.Error()})
+		return
+	}
+
+	var spec pipeline.Spec
+	if err := c.ShouldBindJSON(&spec); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(spec.Stages) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "stages 不能为空"})
+		return
+	}
+
+	nodes, err := pipeline.Materialize(models.GormDB, projectID, spec, service.EnqueueTask)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "物化 pipeline 失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project_id": projectID, "nodes": nodes})
+}
+
+// GetPipeline 返回项目当前的 DAG 及各节点的实时状态，供前端渲染进度图：
+// GET /v1/api/projects/:project_id/pipeline
+func GetPipeline(c *gin.Context) {
+	projectID := c.Param("project_id")
+	nodes, err := models.GetPipelineNodesByProject(models.GormDB, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "加载 pipeline 失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"project_id": projectID, "nodes": nodes})
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	//"StoryToVideo-server/config"
@@ -25,6 +24,11 @@ func CreateProject(c *gin.Context) {
 		StoryText string `form:"StoryText" json:"story_text"`
 		Style     string `form:"Style" json:"style"`
 		ShotCount int    `form:"ShotCount" json:"shot_count"`
+		// Urgency 可选，决定项目名下任务的默认调度优先级（见 models.TaskPriorityForProject），
+		// 不传则按 models.ProjectUrgencyNormal 处理；Priority 可选，直接指定文本任务的
+		// priority，优先级高于 Urgency 的自动推导
+		Urgency  string `form:"Urgency" json:"urgency"`
+		Priority int    `form:"Priority" json:"priority"`
 	}
 	if err := c.ShouldBindQuery(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -35,6 +39,9 @@ func CreateProject(c *gin.Context) {
 	if req.ShotCount <= 0 {
 		req.ShotCount = 5
 	}
+	if req.Urgency == "" {
+		req.Urgency = models.ProjectUrgencyNormal
+	}
 
 	project := models.Project{
 		ID:          uuid.NewString(),
@@ -47,16 +54,11 @@ func CreateProject(c *gin.Context) {
 		VideoUrl:    "",
 		Description: "",
 		ShotCount:   req.ShotCount,
+		Urgency:     req.Urgency,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	// 1) 插入 project 到 DB
-	if err := models.CreateProject(&project); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建项目失败: " + err.Error()})
-		return
-	}
-
 	// 2) 创建项目文本生成任务（project_text）
 	textTask := models.Task{
 		ID:        uuid.NewString(),
@@ -66,6 +68,7 @@ func CreateProject(c *gin.Context) {
 		Status:    models.TaskStatusPending,
 		Progress:  0,
 		Message:   "项目创建任务已创建,正在生成分镜脚本...",
+		Priority:  models.TaskPriorityForProject(models.TaskTypeStoryboard, req.Urgency, req.Priority),
 		Parameters: models.TaskParameters{
 			ShotDefaults: &models.ShotDefaultsParams{
 				ShotCount: req.ShotCount,
@@ -85,25 +88,18 @@ func CreateProject(c *gin.Context) {
 		UpdatedAt:         time.Now(),
 	}
 
-	if err := models.CreateTask(&textTask); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建文本任务失败: " + err.Error()})
-		return
-	}
-	// 将文本任务入队执行
-	if err := service.EnqueueTask(textTask.ID); err != nil {
-		log.Printf("文本任务入队失败: %v", err)
-	}
-
 	// 3) 创建 n 个分镜图片生成任务，状态为 blocked，并设置依赖为 textTask.ID
 	var shotTaskIDs []string
+	shotTasks := make([]*models.Task, 0, req.ShotCount)
 	for i := 0; i < req.ShotCount; i++ {
-		shotTask := models.Task{
+		shotTask := &models.Task{
 			ID:        uuid.NewString(),
 			ProjectId: project.ID,
 			Type:      models.TaskTypeShotImage,
 			Status:    models.TaskStatusBlocked,
 			Progress:  0,
 			Message:   "等待文本任务完成以生成分镜图片",
+			Priority:  models.TaskPriorityForProject(models.TaskTypeShotImage, req.Urgency, 0),
 			Parameters: models.TaskParameters{
 				Shot: &models.ShotParams{
 					Prompt:      "",
@@ -119,14 +115,21 @@ func CreateProject(c *gin.Context) {
 			CreatedAt:         time.Now(),
 			UpdatedAt:         time.Now(),
 		}
-		if err := models.CreateTask(&shotTask); err != nil {
-			log.Printf("创建分镜任务失败: %v", err)
-			continue
-		}
+		shotTasks = append(shotTasks, shotTask)
 		shotTaskIDs = append(shotTaskIDs, shotTask.ID)
-		// 不入队，等待依赖解锁 (文本任务完成后由 watcher 或处理器解锁并入队)
 	}
 
+	// 项目、文本任务、分镜任务放在同一个事务里创建，避免出现项目建好了但任务缺失的半成品状态
+	if err := models.CreateProjectWithTasks(models.GormDB, &project, &textTask, shotTasks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建项目失败: " + err.Error()})
+		return
+	}
+	// 将文本任务入队执行（事务提交之后才入队，避免事务回滚了任务却已经在跑）
+	if err := service.EnqueueTask(textTask.ID); err != nil {
+		log.Printf("文本任务入队失败: %v", err)
+	}
+	// 分镜任务不入队，等待依赖解锁 (文本任务完成后由 watcher 或处理器解锁并入队)
+
 	c.JSON(http.StatusOK, gin.H{
 		"project_id":    project.ID,
 		"text_task_id":  textTask.ID,
@@ -212,103 +215,56 @@ func UpdateProject(c *gin.Context) {
 		Description string `form:"Description" json:"description"`
 		StoryText   string `form:"StoryText" json:"story_text"`
 		ShotCount   int    `form:"ShotCount" json:"shot_count"`
+		// Urgency/Priority 含义同 CreateProject：Urgency 决定重建出来的文本/分镜任务的默认
+		// 调度优先级，Priority 显式指定文本任务的 priority 时优先级更高
+		Urgency  string `form:"Urgency" json:"urgency"`
+		Priority int    `form:"Priority" json:"priority"`
 	}
 	if err := c.ShouldBindQuery(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 1) 更新 title/description（保持原有函数）
-	if err := models.UpdateProjectByID(projectID, req.Title, req.Description); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新项目失败: " + err.Error()})
+	// 读取当前 project，用于计算 story_text / shot_count / urgency 的「生效值」
+	// （请求里有就用请求的，没有就沿用库里已有的），新建的文本/分镜任务要按生效值来。
+	project, err := models.GetProjectByID(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取项目失败: " + err.Error()})
 		return
 	}
 
-	// 2) 可选更新 story_text / shot_count（仅在请求提供时更新）
-	sets := []string{}
-	args := []interface{}{}
+	// 1) 组装本次要更新的 project 字段（title/description 总是更新，其余仅在请求提供时更新）
+	projectUpdates := map[string]interface{}{
+		"title":       req.Title,
+		"description": req.Description,
+	}
 	if req.StoryText != "" {
-		sets = append(sets, "story_text = ?")
-		args = append(args, req.StoryText)
+		projectUpdates["story_text"] = req.StoryText
+		project.StoryText = req.StoryText
 	}
 	if req.ShotCount > 0 {
-		sets = append(sets, "shot_count = ?")
-		args = append(args, req.ShotCount)
-	}
-	if len(sets) > 0 {
-		query := "UPDATE project SET " + strings.Join(sets, ", ") + ", updated_at = ? WHERE id = ?"
-		args = append(args, time.Now(), projectID)
-		if _, err := models.DB.Exec(query, args...); err != nil {
-			log.Printf("额外更新 project 字段失败: %v", err)
-			// 不阻塞主流程，记录日志即可
-		}
+		projectUpdates["shot_count"] = req.ShotCount
+		project.ShotCount = req.ShotCount
 	}
-	// 3) 先取消正在 processing 的任务（尝试向 Worker 发起取消），再删除 pending/blocked
-	rows, err := models.DB.Query(`SELECT id, result FROM task WHERE project_id = ? AND status = ?`, projectID, models.TaskStatusProcessing)
-	if err != nil {
-		log.Printf("查询 processing 任务失败: %v", err)
-	} else {
-		defer rows.Close()
-		for rows.Next() {
-			var tid string
-			var resBytes []byte
-			if err := rows.Scan(&tid, &resBytes); err != nil {
-				continue
-			}
-			// 1) 解析 result 中的 job_id（如果有），并尝试通知 worker 删除
-			var tr models.TaskResult
-			if len(resBytes) > 0 {
-				_ = json.Unmarshal(resBytes, &tr)
-			}
-			if tr.ResourceId != "" {
-				if err := service.CancelWorkerJob(tr.ResourceId); err != nil {
-					log.Printf("通知 worker 删除 job %s 失败: %v", tr.ResourceId, err)
-				} else {
-					log.Printf("已通知 worker 删除 job %s", tr.ResourceId)
-				}
-			}
-
-			// 2) 取消本地轮询（如果存在）
-			if cancelled := service.CancelPollTask(tid); cancelled {
-				log.Printf("Cancelled poll for task %s", tid)
-			}
-			// 3) 标记为 cancelled（入库）
-			msg := "cancelled due to project update"
-			if err := models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil); err != nil {
-				log.Printf("标记任务取消失败 %s: %v", tid, err)
-			} else {
-				log.Printf("任务 %s 标记为 cancelled", tid)
-			}
-		}
-	}
-	// 3) 删除旧的未开始任务（pending / blocked），避免重复
-	res, err := models.DB.Exec(`DELETE FROM task WHERE project_id = ? AND status IN (?, ?)`, projectID, models.TaskStatusPending, models.TaskStatusBlocked)
-	deletedCount := int64(0)
-	if err != nil {
-		log.Printf("删除旧任务失败: %v", err)
-	} else {
-		if n, _ := res.RowsAffected(); n >= 0 {
-			deletedCount = n
-		}
+	if req.Urgency != "" {
+		projectUpdates["urgency"] = req.Urgency
+		project.Urgency = req.Urgency
 	}
-	log.Printf("Deleted %d pending/blocked tasks for project %s", deletedCount, projectID)
+	shotCount := project.ShotCount
 
-	// 4) 重新创建文本任务 + blocked 的分镜任务（和 CreateProject 一致）
-	// 读取当前 project，用于 story_text / shot_count
-	project, err := models.GetProjectByID(projectID)
+	// 2) 先取消正在 processing 的任务（尝试向 Worker 发起取消，属于外部副作用，放在事务外）
+	processingTasks, err := models.GetTasksByProjectAndStatus(models.GormDB, projectID, models.TaskStatusProcessing)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取项目失败: " + err.Error()})
-		return
-	}
-
-	// 若请求中提供新的 StoryText 或 ShotCount，优先使用请求值
-	if req.StoryText != "" {
-		project.StoryText = req.StoryText
+		log.Printf("查询 processing 任务失败: %v", err)
 	}
-	shotCount := project.ShotCount
-	if req.ShotCount > 0 {
-		shotCount = req.ShotCount
+	var processingTaskIDs []string
+	for _, r := range service.CancelTasks(processingTasks) {
+		if r.Err != nil {
+			log.Printf("取消任务 %s 失败: %v", r.TaskID, r.Err)
+		}
+		processingTaskIDs = append(processingTaskIDs, r.TaskID)
 	}
+	cancelMessage := "cancelled due to project update"
 
 	textTask := models.Task{
 		ID:        uuid.NewString(),
@@ -318,6 +274,7 @@ func UpdateProject(c *gin.Context) {
 		Status:    models.TaskStatusPending,
 		Progress:  0,
 		Message:   "项目文本生成任务已创建, 正在生成分镜脚本...",
+		Priority:  models.TaskPriorityForProject(models.TaskTypeStoryboard, project.Urgency, req.Priority),
 		Parameters: models.TaskParameters{
 			ShotDefaults: &models.ShotDefaultsParams{
 				ShotCount: shotCount,
@@ -335,24 +292,18 @@ func UpdateProject(c *gin.Context) {
 		UpdatedAt:         time.Now(),
 	}
 
-	if err := models.CreateTask(&textTask); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建文本任务失败: " + err.Error()})
-		return
-	}
-	if err := service.EnqueueTask(textTask.ID); err != nil {
-		log.Printf("文本任务入队失败: %v", err)
-	}
-
 	// 创建依赖的分镜任务（blocked）
 	var shotTaskIDs []string
+	shotTasks := make([]*models.Task, 0, shotCount)
 	for i := 0; i < shotCount; i++ {
-		shotTask := models.Task{
+		shotTask := &models.Task{
 			ID:        uuid.NewString(),
 			ProjectId: project.ID,
 			Type:      models.TaskTypeShotImage,
 			Status:    models.TaskStatusBlocked,
 			Progress:  0,
 			Message:   "等待文本任务完成以生成分镜图片",
+			Priority:  models.TaskPriorityForProject(models.TaskTypeShotImage, project.Urgency, 0),
 			Parameters: models.TaskParameters{
 				Shot: &models.ShotParams{
 					Prompt:      "",
@@ -368,19 +319,33 @@ func UpdateProject(c *gin.Context) {
 			CreatedAt:         time.Now(),
 			UpdatedAt:         time.Now(),
 		}
-		if err := models.CreateTask(&shotTask); err != nil {
-			log.Printf("创建分镜任务失败: %v", err)
-			continue
-		}
+		shotTasks = append(shotTasks, shotTask)
 		shotTaskIDs = append(shotTaskIDs, shotTask.ID)
 	}
 
+	// project 字段更新、旧任务取消/清理、新任务创建放在同一个事务里完成，避免出现「旧任务已删、
+	// 新任务没建」这种两头都不对的中间态
+	if err := models.ReplaceProjectTasks(models.GormDB, projectID, projectUpdates, processingTaskIDs, cancelMessage, &textTask, shotTasks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新项目任务失败: " + err.Error()})
+		return
+	}
+	for _, tid := range processingTaskIDs {
+		service.PublishTaskEvent(service.TaskEvent{TaskID: tid, ProjectID: projectID, Status: models.TaskStatusCancelled, Message: cancelMessage})
+	}
+	service.PublishTaskEvent(service.TaskEvent{TaskID: textTask.ID, ProjectID: projectID, Status: textTask.Status, Message: textTask.Message})
+	for _, st := range shotTasks {
+		service.PublishTaskEvent(service.TaskEvent{TaskID: st.ID, ProjectID: projectID, Status: st.Status, Message: st.Message})
+	}
+	if err := service.EnqueueTask(textTask.ID); err != nil {
+		log.Printf("文本任务入队失败: %v", err)
+	}
+
 	updatedProject, err := models.GetProjectByID(projectID)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"id":         projectID,
 			"updateAT":   time.Now(),
-			"deleted":    deletedCount,
+			"cancelled":  len(processingTaskIDs),
 			"text_task":  textTask.ID,
 			"shot_tasks": shotTaskIDs,
 		})
@@ -390,7 +355,7 @@ func UpdateProject(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"project":    updatedProject,
 		"updateAT":   updatedProject.UpdatedAt,
-		"deleted":    deletedCount,
+		"cancelled":  len(processingTaskIDs),
 		"text_task":  textTask.ID,
 		"shot_tasks": shotTaskIDs,
 	})
@@ -401,34 +366,23 @@ func DeleteProject(c *gin.Context) {
 	projectID := c.Param("project_id")
 
 	// 在删除前取消正在 processing 的任务并标记 cancelled
-	rows, err := models.DB.Query(`SELECT id, result FROM task WHERE project_id = ? AND status = ?`, projectID, models.TaskStatusProcessing)
-	if err == nil {
-		defer rows.Close()
-		for rows.Next() {
-			var tid string
-			var resBytes []byte
-			if err := rows.Scan(&tid, &resBytes); err != nil {
-				continue
-			}
-
-			// 解析 job_id 并通知 worker 删除
-			var tr models.TaskResult
-			if len(resBytes) > 0 {
-				_ = json.Unmarshal(resBytes, &tr)
-			}
-			if tr.ResourceId != "" {
-				if err := service.CancelWorkerJob(tr.ResourceId); err != nil {
-					log.Printf("通知 worker 删除 job %s 失败: %v", tr.ResourceId, err)
-				} else {
-					log.Printf("已通知 worker 删除 job %s", tr.ResourceId)
-				}
-			}
-
-			if service.CancelPollTask(tid) {
-				log.Printf("Cancelled poll for task %s before project delete", tid)
-			}
-			msg := "cancelled due to project delete"
-			_ = models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil)
+	processingTasks, err := models.GetTasksByProjectAndStatus(models.GormDB, projectID, models.TaskStatusProcessing)
+	if err != nil {
+		log.Printf("查询 processing 任务失败: %v", err)
+	}
+	var processingTaskIDs []string
+	for _, r := range service.CancelTasks(processingTasks) {
+		if r.Err != nil {
+			log.Printf("取消任务 %s 失败: %v", r.TaskID, r.Err)
+		}
+		processingTaskIDs = append(processingTaskIDs, r.TaskID)
+	}
+	msg := "cancelled due to project delete"
+	if _, err := models.UpdateTasksStatusBulk(processingTaskIDs, []string{models.TaskStatusProcessing}, models.TaskStatusCancelled, &msg); err != nil {
+		log.Printf("标记任务取消失败: %v", err)
+	} else {
+		for _, tid := range processingTaskIDs {
+			service.PublishTaskEvent(service.TaskEvent{TaskID: tid, ProjectID: projectID, Status: models.TaskStatusCancelled, Message: msg})
 		}
 	}
 
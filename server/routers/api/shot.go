@@ -9,11 +9,35 @@ import (
 
 	"StoryToVideo-server/models"
 	"StoryToVideo-server/service"
+	"StoryToVideo-server/service/orchestrator"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
+// shotWithModeration 在 Shot 的基础上附带一个 moderation 子对象（最近一次审核记录的状态/
+// 备注），供前端展示"为什么这个资源现在看不到"
+type shotWithModeration struct {
+	models.Shot
+	Moderation gin.H `json:"moderation"`
+}
+
+// withModerationView 查询 shot 最近一次审核记录：还没有记录（从没触发过 TaskTypeModeration，
+// 或者资源还在生成中）时视为 passed，不遮挡；状态不是 passed（waiting/blocked/manual_review）
+// 时把图片/音频地址清空，
+// 避免前端绕过审核直接拿到被拦截的资源地址
+func withModerationView(shot models.Shot) shotWithModeration {
+	record, err := models.GetLatestModerationRecordByShotID(models.GormDB, shot.ID)
+	if err != nil {
+		return shotWithModeration{Shot: shot, Moderation: gin.H{"status": models.ModerationStatusPassed}}
+	}
+	if record.Status != models.ModerationStatusPassed {
+		shot.ImagePath = ""
+		shot.AudioPath = ""
+	}
+	return shotWithModeration{Shot: shot, Moderation: gin.H{"status": record.Status, "remarks": record.Remarks}}
+}
+
 // 获取分镜列表
 func GetShots(c *gin.Context) {
 	projectID := c.Param("project_id")
@@ -24,8 +48,13 @@ func GetShots(c *gin.Context) {
 		return
 	}
 
+	out := make([]shotWithModeration, 0, len(shots))
+	for _, shot := range shots {
+		out = append(out, withModerationView(shot))
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"shots":       shots,
+		"shots":       out,
 		"project_id":  projectID,
 		"total_shots": len(shots),
 	})
@@ -79,7 +108,9 @@ func UpdateShot(c *gin.Context) {
 				log.Printf("Cancelled poll for task %s (shot update)", tid)
 			}
 			msg := "cancelled due to shot update"
-			_ = models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil)
+			if err := models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil); err == nil {
+				service.PublishTaskEvent(service.TaskEvent{TaskID: tid, ProjectID: projectID, Status: models.TaskStatusCancelled, Message: msg})
+			}
 		}
 	}
 
@@ -120,15 +151,14 @@ func UpdateShot(c *gin.Context) {
 		UpdatedAt:         time.Now(),
 	}
 
-	if err := models.CreateTask(&task); err != nil {
+	// 走 orchestrator.Submit 而不是直接 CreateTask+EnqueueTask，这样这条重生任务在崩溃重启后
+	// 也能跟 pipeline 创建的任务一样被 ResumeBlockedTasks 扫到（目前没有 depends_on，等价于
+	// 立即入队，但入口统一方便以后给重生任务挂依赖）
+	if err := orchestrator.Submit(models.GormDB, &task, service.EnqueueTask); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建任务失败: " + err.Error()})
 		return
 	}
-
-	if err := service.EnqueueTask(task.ID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "任务入队失败"})
-		return
-	}
+	service.PublishTaskUpdate(&task)
 
 	c.JSON(http.StatusOK, gin.H{
 		"shot_id": shotID,
@@ -149,7 +179,7 @@ func GetShotDetail(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"shot": shot,
+		"shot": withModerationView(shot),
 	})
 }
 
@@ -186,7 +216,9 @@ func DeleteShot(c *gin.Context) {
 					log.Printf("Cancelled poll for task %s (shot delete)", tid)
 				}
 				msg := "cancelled due to shot delete"
-				_ = models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil)
+				if err := models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil); err == nil {
+					service.PublishTaskEvent(service.TaskEvent{TaskID: tid, Status: models.TaskStatusCancelled, Message: msg})
+				}
 			}
 		}
 		if _, err := models.DB.Exec(`DELETE FROM shot WHERE id = ?`, shotID); err != nil {
@@ -207,7 +239,9 @@ func DeleteShot(c *gin.Context) {
 					log.Printf("Cancelled poll for task %s (shot delete)", tid)
 				}
 				msg := "cancelled due to shot delete"
-				_ = models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil)
+				if err := models.UpdateTaskStatus(tid, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil); err == nil {
+					service.PublishTaskEvent(service.TaskEvent{TaskID: tid, ProjectID: projectID, Status: models.TaskStatusCancelled, Message: msg})
+				}
 			}
 		}
 
@@ -229,8 +263,9 @@ func GenerateShotVideo(c *gin.Context) {
 	projectID := c.Param("project_id")
 
 	var req struct {
-		ShotID string `json:"shot_id" form:"shot_id"`
-		FPS    int    `json:"fps" form:"fps"`
+		ShotID    string   `json:"shot_id" form:"shot_id"`
+		FPS       int      `json:"fps" form:"fps"`
+		DependsOn []string `json:"depends_on" form:"depends_on"`
 	}
 	// 允许从 Query 或 Body 绑定
 	if err := c.ShouldBind(&req); err != nil {
@@ -255,26 +290,27 @@ func GenerateShotVideo(c *gin.Context) {
 				FPS:        req.FPS, // 默认值或从 req 获取
 				Resolution: "1280x720",
 			},
-			Shot: &models.ShotParams{},
+			Shot:      &models.ShotParams{},
+			DependsOn: req.DependsOn,
 		},
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
 	}
 
-	// 2. 存入数据库
-	if err := models.CreateTask(&task); err != nil {
+	// 2. 存入数据库；depends_on 未满足时落成 blocked 且不入队，等上游任务 finished 后由
+	// orchestrator 自动解锁
+	if err := orchestrator.Submit(models.GormDB, &task, service.EnqueueTask); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "创建任务失败: " + err.Error()})
 		return
 	}
+	service.PublishTaskUpdate(&task)
 
-	// 3. 推送到 Redis 队列
-	if err := service.EnqueueTask(task.ID); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "任务入队失败"})
-		return
+	message := "视频生成任务已创建"
+	if task.Status == models.TaskStatusBlocked {
+		message = "视频生成任务已创建，等待依赖任务完成"
 	}
-
 	c.JSON(http.StatusOK, gin.H{
-		"message":    "视频生成任务已创建",
+		"message":    message,
 		"project_id": projectID,
 		"shot_id":    req.ShotID,
 		"task_id":    task.ID,
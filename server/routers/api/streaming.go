@@ -0,0 +1,155 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service"
+	"StoryToVideo-server/service/streaming"
+
+	"github.com/gin-gonic/gin"
+)
+
+const hlsSegmentExpiry = time.Hour
+
+// GetShotStreamPlaylist 返回某个分镜的 HLS 主播放列表：GET /v1/api/shots/:shot_id/stream.m3u8
+// 由于 master.m3u8 中的每个 variant 条目不能直接指向 MinIO（分片签名会过期），
+// 这里把每个 variant 重写为指向本服务 variant 代理路由的绝对 URL。
+func GetShotStreamPlaylist(c *gin.Context) {
+	shotID := c.Param("shot_id")
+
+	shot, err := models.GetShotByIDGorm(models.GormDB, shotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分镜未找到: " + err.Error()})
+		return
+	}
+	if shot.HLSMasterPath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该分镜尚未完成 HLS 打包"})
+		return
+	}
+
+	content, err := service.GetObjectText(shot.HLSMasterPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "读取播放列表失败: " + err.Error()})
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	baseURL := fmt.Sprintf("%s://%s/v1/api/shots/%s/variants", scheme, c.Request.Host, shotID)
+
+	rewritten := streaming.RewriteMasterPlaylist(content, func(variant string) string {
+		return fmt.Sprintf("%s/%s/index.m3u8", baseURL, variant)
+	})
+
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(rewritten))
+}
+
+// GetShotVariantPlaylist 返回某一码率档的 variant 播放列表，分片地址现签：
+// GET /v1/api/shots/:shot_id/variants/:variant/index.m3u8
+func GetShotVariantPlaylist(c *gin.Context) {
+	shotID := c.Param("shot_id")
+	variant := c.Param("variant")
+
+	shot, err := models.GetShotByIDGorm(models.GormDB, shotID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "分镜未找到: " + err.Error()})
+		return
+	}
+	if shot.HLSMasterPath == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "该分镜尚未完成 HLS 打包"})
+		return
+	}
+
+	objectPrefix := fmt.Sprintf("shots/%s/hls/%s", shotID, variant)
+	content, err := service.GetObjectText(objectPrefix + "/index.m3u8")
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "variant 播放列表未找到: " + err.Error()})
+		return
+	}
+
+	rewritten, err := streaming.RewriteVariantPlaylist(content, func(filename string) (string, error) {
+		return service.PresignObject(objectPrefix+"/"+filename, hlsSegmentExpiry)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "分片签名失败: " + err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(rewritten))
+}
+
+// GetProjectPreviewPlaylist 把项目下所有已 stream_ready 的分镜按 Order 拼接成一个连续播放列表
+// （EXT-X-DISCONTINUITY 分隔），默认取 720p 档位：GET /v1/api/projects/:project_id/preview.m3u8
+func GetProjectPreviewPlaylist(c *gin.Context) {
+	projectID := c.Param("project_id")
+	previewVariant := c.DefaultQuery("variant", "720p")
+
+	shots, err := models.GetShotsByProjectID(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取分镜失败: " + err.Error()})
+		return
+	}
+
+	builder := newM3U8Builder()
+	first := true
+	for _, shot := range shots {
+		if shot.Status != models.ShotStatusStreamReady || shot.HLSMasterPath == "" {
+			continue
+		}
+		objectPrefix := fmt.Sprintf("shots/%s/hls/%s", shot.ID, previewVariant)
+		content, err := service.GetObjectText(objectPrefix + "/index.m3u8")
+		if err != nil {
+			continue // 该分镜这一档还没打包好，跳过而不是整个预览失败
+		}
+		segments := streaming.ParseVariantSegments(content)
+		if len(segments) == 0 {
+			continue
+		}
+		if !first {
+			builder.WriteDiscontinuity()
+		}
+		first = false
+		for _, seg := range segments {
+			presigned, err := service.PresignObject(objectPrefix+"/"+seg.Filename, hlsSegmentExpiry)
+			if err != nil {
+				continue
+			}
+			builder.WriteSegment(seg.Duration, presigned)
+		}
+	}
+
+	c.Data(http.StatusOK, "application/vnd.apple.mpegurl", []byte(builder.Build()))
+}
+
+// m3u8Builder 拼接一个带 EXT-X-DISCONTINUITY 的 VOD 播放列表
+type m3u8Builder struct {
+	body []string
+}
+
+func newM3U8Builder() *m3u8Builder {
+	return &m3u8Builder{}
+}
+
+func (b *m3u8Builder) WriteDiscontinuity() {
+	b.body = append(b.body, "#EXT-X-DISCONTINUITY")
+}
+
+func (b *m3u8Builder) WriteSegment(duration float64, url string) {
+	b.body = append(b.body, fmt.Sprintf("#EXTINF:%.3f,", duration), url)
+}
+
+func (b *m3u8Builder) Build() string {
+	header := []string{"#EXTM3U", "#EXT-X-VERSION:3", "#EXT-X-TARGETDURATION:4", "#EXT-X-PLAYLIST-TYPE:VOD"}
+	footer := []string{"#EXT-X-ENDLIST"}
+	all := append(append(header, b.body...), footer...)
+	out := ""
+	for _, line := range all {
+		out += line + "\n"
+	}
+	return out
+}
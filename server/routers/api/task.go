@@ -1,10 +1,17 @@
 package api
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"StoryToVideo-server/models"
+	"StoryToVideo-server/service"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -16,8 +23,46 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// 任务进度 WebSocket 推送（改为以数据库为来源：先读取 DB，然后循环轮询 DB 并推送）
-// 外部服务轮询并写回 DB 的逻辑应由后台协程/任务执行器负责，这里只订阅并推送 DB 中的最新数据。
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = (wsPongWait * 9) / 10
+)
+
+// isTerminalTaskStatus 判断任务是否已经到达不会再变化的终态，TaskProgressWebSocket/SSE
+// 都用它决定是否在推送一次快照后就直接结束连接。
+func isTerminalTaskStatus(status string) bool {
+	return status == models.TaskStatusSuccess || status == models.TaskStatusFailed || status == models.TaskStatusCancelled
+}
+
+// loadTaskEventSnapshot 取任务当前状态的 TaskEvent 快照：优先读 task:last:<task_id>（几分钟
+// TTL 的兜底缓存，命中则免一次 DB 查询），未命中时退回到原来的 DB 读取。
+func loadTaskEventSnapshot(ctx context.Context, taskID string) (service.TaskEvent, error) {
+	if cached, err := service.LastTaskEvent(ctx, taskID); err == nil && cached != nil {
+		return *cached, nil
+	}
+	t, err := models.GetTaskByID(taskID)
+	if err != nil {
+		return service.TaskEvent{}, err
+	}
+	return service.TaskEvent{
+		TaskID:    t.ID,
+		ProjectID: t.ProjectId,
+		Status:    t.Status,
+		Progress:  t.Progress,
+		Message:   t.Message,
+		Result:    &t.Result,
+		UpdatedAt: t.UpdatedAt,
+	}, nil
+}
+
+// 任务进度 WebSocket 推送：GET /tasks/:task_id/wss
+// 不再每秒轮询 DB，而是先 SUBSCRIBE service.SubscribeTaskEvents 在 task:events:<task_id> 上
+// 的 Redis Pub/Sub（调用 models.UpdateTaskStatus / Task.UpdateStatus 的地方提交后各自发布一条），
+// 再发一次当前快照，然后持续转发事件直到看到 finished/failed/cancelled 或客户端断开。
+// query 参数 cursor（RFC3339，对应客户端上次收到的 updated_at）用于重连去重：快照不比
+// cursor 新时跳过重发。ping/pong 心跳按 wsPingPeriod/wsPongWait 维持，读不到 pong 即判定
+// 死连接并退出。
 func TaskProgressWebSocket(c *gin.Context) {
 	taskID := c.Param("task_id")
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
@@ -27,42 +72,173 @@ func TaskProgressWebSocket(c *gin.Context) {
 	}
 	defer conn.Close()
 
-	// 先从 DB 读取当前任务状态并推送
-	t, err := models.GetTaskByID(taskID)
+	var cursor time.Time
+	if v := c.Query("cursor"); v != "" {
+		if ts, err := time.Parse(time.RFC3339, v); err == nil {
+			cursor = ts
+		}
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// 先订阅再读快照，避免两者之间发生的更新被错过
+	sub := service.SubscribeTaskEvents(ctx, taskID)
+	defer sub.Close()
+
+	snapshot, err := loadTaskEventSnapshot(ctx, taskID)
 	if err != nil {
-		// 若任务不存在，仍可保持连接并等待任务被创建/更新，或直接返回错误
-		conn.WriteJSON(map[string]interface{}{"error": "task not found: " + err.Error()})
+		_ = conn.WriteJSON(map[string]interface{}{"error": "task not found: " + err.Error()})
+		return
+	}
+	if cursor.IsZero() || snapshot.UpdatedAt.After(cursor) {
+		if err := conn.WriteJSON(snapshot); err != nil {
+			return
+		}
+	}
+	if isTerminalTaskStatus(snapshot.Status) {
 		return
 	}
-	_ = conn.WriteJSON(t)
 
-	// 轮询 DB 并推送差异（简单实现：每秒查询一次直到状态为 finished）
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		return nil
+	})
 
-	prevStatus := t.Status
-	prevProgress := t.Progress
+	// 读 goroutine 只负责处理 pong/关闭帧并在断开时通知主循环，写操作都留在主循环里，
+	// 避免两个 goroutine 同时往同一个 conn 写
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	pingTicker := time.NewTicker(wsPingPeriod)
+	defer pingTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case <-pingTicker.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			var evt service.TaskEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &evt); err != nil {
+				log.Printf("解析任务事件失败 task=%s: %v", taskID, err)
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteJSON(evt); err != nil {
+				return
+			}
+			if isTerminalTaskStatus(evt.Status) {
+				return
+			}
+		}
+	}
+}
+
+// 任务进度 SSE 推送：GET /tasks/:task_id/events
+// 取代浏览器侧的 pollJobResult 式轮询：先从 DB 读取当前任务快照推送一次，若任务已有关联
+// 的 job_id 且尚未终态，则订阅 service.JobHub 中同一个 job 的进度事件流，每次 Worker 回调
+// 到达都原样转发一条 SSE message，直到任务进入终态或客户端断开连接。
+func TaskProgressSSE(c *gin.Context) {
+	taskID := c.Param("task_id")
+	t, err := models.GetTaskByID(taskID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found: " + err.Error()})
+		return
+	}
 
-	for range ticker.C {
-		cur, err := models.GetTaskByID(taskID)
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+
+	writeEvent := func(payload interface{}) bool {
+		data, err := json.Marshal(payload)
 		if err != nil {
-			// 若查询失败，继续重试；也可以选择断开连接
-			continue
+			return false
+		}
+		if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", data); err != nil {
+			return false
 		}
+		c.Writer.Flush()
+		return true
+	}
+
+	if !writeEvent(t) {
+		return
+	}
+	if t.Status == models.TaskStatusSuccess || t.Status == models.TaskStatusFailed || t.Status == models.TaskStatusCancelled {
+		return
+	}
 
-		// 若状态/进度等有变化则推送
-		if cur.Status != prevStatus || cur.Progress != prevProgress {
-			if err := conn.WriteJSON(cur); err != nil {
-				break
+	jobID := t.Result.ResourceId
+	if jobID == "" {
+		// 任务还没有提交给 Worker（没有 job_id 可订阅），保持连接直到客户端断开
+		<-c.Request.Context().Done()
+		return
+	}
+
+	sub, cancel := service.DefaultJobHub.Subscribe(jobID)
+	defer cancel()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case evt := <-sub:
+			if !writeEvent(evt) {
+				return
+			}
+			if evt.Status == models.TaskStatusSuccess || evt.Status == models.TaskStatusFailed {
+				return
 			}
-			prevStatus = cur.Status
-			prevProgress = cur.Progress
 		}
+	}
+}
+
+// ProjectProgressSSE 推送一个项目下所有任务的进度变化：GET /projects/:project_id/events
+// 订阅 service.SubscribeProjectEvents 在 task:events:project:<project_id> 上的 Redis
+// Pub/Sub 广播，让前端不用为项目里每个 task_id 各开一条连接；不做初始快照（项目下任务数量
+// 不定，交给调用方先走 ListTasks 拿一次全量），直到客户端断开才结束。
+func ProjectProgressSSE(c *gin.Context) {
+	projectID := c.Param("project_id")
+
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+
+	ctx := c.Request.Context()
+	sub := service.SubscribeProjectEvents(ctx, projectID)
+	defer sub.Close()
 
-		if cur.Status == "finished" || cur.Status == "failed" {
-			// 发送最终状态后关闭连接
-			_ = conn.WriteJSON(cur)
-			break
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-sub.Channel():
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintf(c.Writer, "data: %s\n\n", msg.Payload); err != nil {
+				return
+			}
+			c.Writer.Flush()
 		}
 	}
 }
@@ -77,3 +253,249 @@ func GetTaskStatus(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"task": t})
 }
+
+// ListTasks 分页/过滤/多重排序地查询任务，供管理后台排查卡住的 DAG，也供后台扫描器按条件
+// 分批取候选任务：
+// GET /v1/api/tasks?project_id=&shot_id=&type=generate_storyboard,generate_shot&
+//
+//	status=pending,failed&created_after=&created_before=&keyword=&page=&page_size=&
+//	sort=status:asc,created_at:desc
+//
+// GET /v1/api/projects/:project_id/tasks 复用同一实现，project_id 取自路径参数。
+// created_after/created_before 为 RFC3339 时间；status 额外接受别名 "done"（等价于
+// TaskStatusSuccess）方便使用者不用记住内部 "finished" 这个历史命名。
+func ListTasks(c *gin.Context) {
+	filter := models.TaskListFilter{
+		ProjectID: c.Param("project_id"),
+		ShotID:    c.Query("shot_id"),
+		Keyword:   c.Query("keyword"),
+		Sort:      c.Query("sort"),
+	}
+	if filter.ProjectID == "" {
+		filter.ProjectID = c.Query("project_id")
+	}
+	if types := c.Query("type"); types != "" {
+		filter.Types = strings.Split(types, ",")
+	}
+	if statuses := c.Query("status"); statuses != "" {
+		for _, s := range strings.Split(statuses, ",") {
+			s = strings.TrimSpace(s)
+			if s == "done" {
+				s = models.TaskStatusSuccess
+			}
+			filter.Statuses = append(filter.Statuses, s)
+		}
+	}
+	if v := c.Query("created_after"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "created_after 格式应为 RFC3339: " + err.Error()})
+			return
+		}
+		filter.CreatedAfter = &t
+	}
+	if v := c.Query("created_before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "created_before 格式应为 RFC3339: " + err.Error()})
+			return
+		}
+		filter.CreatedBefore = &t
+	}
+	if v := c.Query("page"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			filter.Page = n
+		}
+	}
+	if v := c.Query("page_size"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			filter.PageSize = n
+		}
+	}
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.PageSize <= 0 {
+		filter.PageSize = models.DefaultTaskPageSize
+	}
+	if filter.PageSize > models.MaxTaskPageSize {
+		filter.PageSize = models.MaxTaskPageSize
+	}
+
+	tasks, total, err := models.ListTasks(models.GormDB, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询任务列表失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"tasks":     tasks,
+		"total":     total,
+		"page":      filter.Page,
+		"page_size": filter.PageSize,
+	})
+}
+
+// RetryTask 忽略 next_retry_at，立即强制重试：POST /v1/api/tasks/:task_id/retry
+// 只对 failed / retry_scheduled 状态的任务生效，已经在跑或已经结束的任务不受影响。
+func RetryTask(c *gin.Context) {
+	taskID := c.Param("task_id")
+	task, err := models.GetTaskByIDGorm(models.GormDB, taskID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found: " + err.Error()})
+		return
+	}
+	if task.Status != models.TaskStatusFailed && task.Status != models.TaskStatusRetryScheduled {
+		c.JSON(http.StatusConflict, gin.H{"error": "任务当前状态不支持重试: " + task.Status})
+		return
+	}
+
+	if err := models.UpdateTaskStatus(taskID, models.TaskStatusPending, nil, nil, nil, nil, nil, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重置任务状态失败: " + err.Error()})
+		return
+	}
+	service.PublishTaskEvent(service.TaskEvent{TaskID: taskID, ProjectID: task.ProjectId, Status: models.TaskStatusPending})
+	if err := service.EnqueueTask(taskID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "任务入队失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task_id": taskID, "status": models.TaskStatusPending})
+}
+
+// TaskHeartbeat 供 worker 周期性调用证明任务还活着：PATCH /v1/api/tasks/:task_id/heartbeat，
+// 只刷新 updated_at（可选带 progress/message），不碰 status。StallReaper 把超过
+// config.AppConfig.Worker.Heartbeats 对应间隔 3 倍没有心跳的 processing 任务判定为 stalled。
+func TaskHeartbeat(c *gin.Context) {
+	taskID := c.Param("task_id")
+	var req struct {
+		Progress *int    `json:"progress"`
+		Message  *string `json:"message"`
+	}
+	_ = c.ShouldBindJSON(&req) // progress/message 都是可选的，body 可以完全不传
+	if err := models.UpdateTaskHeartbeat(taskID, req.Progress, req.Message); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新心跳失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task_id": taskID})
+}
+
+// RetryFailedShards 只重试一个分片批次任务里已经失败的那些分片，不用把整个批次推倒重来：
+// POST /v1/api/tasks/:task_id/retry_failed_shards，:task_id 是 SubmitSharded* 创建的父任务
+func RetryFailedShards(c *gin.Context) {
+	taskID := c.Param("task_id")
+	retried, err := service.RetryFailedShards(models.GormDB, taskID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task_id": taskID, "retried_shard_tasks": retried})
+}
+
+// cancellableTaskStatuses 是"还没结束"、允许被取消的状态集合：CancelTasksBatch 不传
+// statuses 时按这个集合筛选 project_id 下待取消的任务，也用来限制 models.UpdateTasksStatusBulk
+// 最终落库的 WHERE 范围——即使调用方直接传了 ids，也不会把已经 success/blocked_by_moderation
+// 等终态的任务覆盖成 cancelled。
+var cancellableTaskStatuses = []string{
+	models.TaskStatusPending, models.TaskStatusBlocked,
+	models.TaskStatusProcessing, models.TaskStatusRetryScheduled,
+}
+
+// CancelTasksBatch 一次请求批量取消多个任务：POST /v1/api/tasks/cancel
+// Body 二选一：{"ids": ["t1", "t2"]} 直接给定任务 ID 列表；或 {"project_id": "p1",
+// "statuses": ["pending", "processing"]} 按项目 + 状态筛选（不传 statuses 则默认覆盖所有
+// "还没结束"的状态）。内部先用一次 SELECT ... WHERE id IN (...) 取出任务详情，
+// service.CancelTasks 并发通知 worker/取消轮询，再用 models.UpdateTasksStatusBulk 一次
+// UPDATE 把它们标成 cancelled，取代过去前端要对每个任务发一次 POST /retry 式请求、后端也
+// 对每个任务单独查询+更新的做法；UPDATE 本身也按 cancellableTaskStatuses 限定范围，ids 是
+// 直接传入时也不会误伤已经终态的任务。
+func CancelTasksBatch(c *gin.Context) {
+	var req struct {
+		IDs       []string `json:"ids"`
+		ProjectID string   `json:"project_id"`
+		Statuses  []string `json:"statuses"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids := req.IDs
+	if len(ids) == 0 {
+		if req.ProjectID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "必须提供 ids，或 project_id (+ statuses)"})
+			return
+		}
+		statuses := req.Statuses
+		if len(statuses) == 0 {
+			statuses = cancellableTaskStatuses
+		}
+		filter := models.TaskListFilter{ProjectID: req.ProjectID, Statuses: statuses, Page: 1, PageSize: models.MaxTaskPageSize}
+		tasks, _, err := models.ListTasks(models.GormDB, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "查询待取消任务失败: " + err.Error()})
+			return
+		}
+		for _, t := range tasks {
+			ids = append(ids, t.ID)
+		}
+	}
+	if len(ids) == 0 {
+		c.JSON(http.StatusOK, gin.H{"cancelled": 0, "failed": []string{}})
+		return
+	}
+
+	tasks, err := models.GetTasksByIDs(models.GormDB, ids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询任务详情失败: " + err.Error()})
+		return
+	}
+
+	results := service.CancelTasks(tasks)
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.TaskID)
+			log.Printf("取消任务 %s 失败: %v", r.TaskID, r.Err)
+		}
+	}
+
+	msg := "cancelled via batch cancel endpoint"
+	affected, err := models.UpdateTasksStatusBulk(ids, cancellableTaskStatuses, models.TaskStatusCancelled, &msg)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "批量更新任务状态失败: " + err.Error()})
+		return
+	}
+	for _, t := range tasks {
+		service.PublishTaskEvent(service.TaskEvent{TaskID: t.ID, ProjectID: t.ProjectId, Status: models.TaskStatusCancelled, Message: msg})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"cancelled": affected, "failed": failed})
+}
+
+// UpdateTaskPriority 调整一个还没跑完的任务在调度队列里的优先级（数值越小越先被
+// FetchNextRunnableTasks 选中）：PATCH /v1/api/tasks/:task_id/priority
+// Body: {"priority": 5}
+// 只影响尚未入队的 pending 任务排序，已经进入 asynq 对应优先级队列的任务不会被挪动。
+func UpdateTaskPriority(c *gin.Context) {
+	taskID := c.Param("task_id")
+	var req struct {
+		Priority int `json:"priority"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Priority <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "priority 必须是正整数"})
+		return
+	}
+
+	if _, err := models.GetTaskByIDGorm(models.GormDB, taskID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found: " + err.Error()})
+		return
+	}
+	if err := models.UpdateTaskPriority(taskID, req.Priority); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "更新任务优先级失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"task_id": taskID, "priority": req.Priority})
+}
@@ -2,13 +2,13 @@
 package api
 
 import (
-    "log"
     "net/http"
     "time"
 
     "StoryToVideo-server/models"
 
     "StoryToVideo-server/service"
+    "StoryToVideo-server/service/orchestrator"
 
     "github.com/gin-gonic/gin"
     "github.com/google/uuid"
@@ -20,6 +20,13 @@ import (
 func GenerateProjectTTS(c *gin.Context) {
     projectID := c.Param("project_id")
 
+    var req struct {
+        DependsOn []string `json:"depends_on"`
+    }
+    if err := c.ShouldBindJSON(&req); err != nil {
+        // body 可以完全不传，depends_on 是可选的
+    }
+
     // 默认 TTS 参数（可扩展为从请求体读取）
     ttsDefaults := models.TTSParams{
         Voice:      "xiaoyan",
@@ -28,6 +35,25 @@ func GenerateProjectTTS(c *gin.Context) {
         Format:     "mp3",
     }
 
+    // 有分镜且不依赖其它任务时按分片提交：每个 shot 各自入队、各自重试，不再让整条项目音轨
+    // 卡在同一个 asynq 任务里；depends_on 场景目前还是单任务走 orchestrator.Submit，分片批次
+    // 暂不支持 blocked 语义
+    if len(req.DependsOn) == 0 {
+        if shots, err := models.GetShotsByProjectID(projectID); err == nil && len(shots) > 0 {
+            parent, err := service.SubmitShardedTTS(projectID, shots, ttsDefaults)
+            if err != nil {
+                c.JSON(http.StatusInternalServerError, gin.H{"error": "创建 TTS 分片任务失败: " + err.Error()})
+                return
+            }
+            c.JSON(http.StatusOK, gin.H{
+                "task_id":    parent.ID,
+                "message":    parent.Message,
+                "project_id": projectID,
+            })
+            return
+        }
+    }
+
     task := models.Task{
         ID:        uuid.NewString(),
         ProjectId: projectID,
@@ -36,7 +62,8 @@ func GenerateProjectTTS(c *gin.Context) {
         Progress:  0,
         Message:   "项目音频 (TTS) 生成任务已创建",
         Parameters: models.TaskParameters{
-            TTS: &ttsDefaults,
+            TTS:       &ttsDefaults,
+            DependsOn: req.DependsOn,
         },
         Result:            models.TaskResult{},
         Error:             "",
@@ -45,17 +72,18 @@ func GenerateProjectTTS(c *gin.Context) {
         UpdatedAt:         time.Now(),
     }
 
-    if err := models.CreateTask(&task); err != nil {
+    // orchestrator.Submit 会在 depends_on 未满足时把任务落成 blocked 且不入队，等依赖的任务
+    // finished 后由 orchestrator.OnTaskFinished 自动解锁入队
+    if err := orchestrator.Submit(models.GormDB, &task, service.EnqueueTask); err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "创建 TTS 任务失败: " + err.Error()})
         return
     }
+    service.PublishTaskUpdate(&task)
 
-    if err := service.EnqueueTask(task.ID); err != nil {
-        log.Printf("TTS 任务入队失败: %v", err)
-        // 仍返回成功创建但提示入队失败
+    if task.Status == models.TaskStatusBlocked {
         c.JSON(http.StatusOK, gin.H{
             "task_id":    task.ID,
-            "message":    "音频任务已创建，但入队失败",
+            "message":    "音频任务已创建，等待依赖任务完成",
             "project_id": projectID,
         })
         return
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"log"
+	"net/http"
+
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// WorkerCallback 接收 Worker 的进度/完成回调：POST /v1/api/worker/callback
+// Body: {"job_id","status","progress","message","result"}
+// 把进度写回对应 Task 行，并通过 service.DefaultJobHub 唤醒 waitJobResult 中阻塞等待
+// 该 job 终态的 HandleGenerateTask goroutine，同时转发给 /tasks/:id/events 的 SSE 订阅者。
+// 只有 config.yaml 中 worker.use_callback=true 时，HandleGenerateTask 才会等待这里的事件；
+// 未开启回调的 Worker 仍走旧的 pollJobResult 轮询，这里收到的回调不会写回 Task 行，只会经
+// JobHub 转发给 SSE 订阅者。
+func WorkerCallback(c *gin.Context) {
+	var req struct {
+		JobID    string             `json:"job_id"`
+		Status   string             `json:"status"`
+		Progress int                `json:"progress"`
+		Message  string             `json:"message"`
+		Result   *models.TaskResult `json:"result"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.JobID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "job_id 不能为空"})
+		return
+	}
+
+	status := service.NormalizeWorkerStatus(req.Status)
+
+	if taskID, ok := service.DefaultJobHub.TaskIDFor(req.JobID); ok {
+		if status != models.TaskStatusFailed {
+			if err := models.UpdateTaskStatus(taskID, status, &req.Progress, &req.Message, req.Result, nil, nil, nil); err != nil {
+				log.Printf("worker回调写入任务进度失败: %v", err)
+			} else if t, err := models.GetTaskByID(taskID); err == nil {
+				service.PublishTaskEvent(service.TaskEvent{
+					TaskID:    taskID,
+					ProjectID: t.ProjectId,
+					Status:    status,
+					Progress:  req.Progress,
+					Message:   req.Message,
+					Result:    req.Result,
+				})
+			}
+		}
+		// 失败状态不在这里落库：Publish 下面会以 terminal 事件唤醒 waitJobResult，真正的
+		// task.UpdateStatus(Failed, ...) 调用（含重试判断）交给 HandleGenerateTask 在收到
+		// 结果后统一处理，避免这里和那里各算一次 attempts
+	}
+
+	service.DefaultJobHub.Publish(service.JobProgress{
+		JobID:    req.JobID,
+		Status:   status,
+		Progress: req.Progress,
+		Message:  req.Message,
+		Result:   req.Result,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"job_id": req.JobID, "status": status})
+}
@@ -15,7 +15,15 @@ func InitRouter() *gin.Engine {
 		v1.GET("/projects/:project_id", api.GetProject)
 		v1.PUT("/projects/:project_id", api.UpdateProject)
 		v1.DELETE("/projects/:project_id", api.DeleteProject)
+		v1.GET("/tasks", api.ListTasks)
+		v1.POST("/tasks/cancel", api.CancelTasksBatch)
 		v1.GET("/tasks/:task_id", api.GetTaskStatus)
+		v1.POST("/tasks/:task_id/retry", api.RetryTask)
+		v1.POST("/tasks/:task_id/retry_failed_shards", api.RetryFailedShards)
+		v1.PATCH("/tasks/:task_id/heartbeat", api.TaskHeartbeat)
+		v1.PATCH("/tasks/:task_id/priority", api.UpdateTaskPriority)
+		v1.GET("/projects/:project_id/tasks", api.ListTasks)
+		v1.GET("/projects/:project_id/events", api.ProjectProgressSSE)
 		//v1.POST("/projects/:project_id/shots", api.CreateShot)
 		v1.POST("/projects/:project_id/shots/:shot_id", api.UpdateShot)
 		v1.GET("/projects/:project_id/shots", api.GetShots)
@@ -23,7 +31,15 @@ func InitRouter() *gin.Engine {
 		v1.DELETE("/shots/:shot_id", api.DeleteShot)
 		v1.POST("/projects/:project_id/video", api.GenerateShotVideo)
 		v1.POST("/projects/:project_id/tts", api.GenerateProjectTTS)
+		v1.POST("/moderation/callback", api.ModerationCallback)
+		v1.POST("/worker/callback", api.WorkerCallback)
+		v1.POST("/projects/:project_id/pipeline", api.CreatePipeline)
+		v1.GET("/projects/:project_id/pipeline", api.GetPipeline)
+		v1.GET("/shots/:shot_id/stream.m3u8", api.GetShotStreamPlaylist)
+		v1.GET("/shots/:shot_id/variants/:variant/index.m3u8", api.GetShotVariantPlaylist)
+		v1.GET("/projects/:project_id/preview.m3u8", api.GetProjectPreviewPlaylist)
 	}
 	r.GET("/tasks/:task_id/wss", api.TaskProgressWebSocket)
+	r.GET("/tasks/:task_id/events", api.TaskProgressSSE)
 	return r
 }
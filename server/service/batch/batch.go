@@ -0,0 +1,206 @@
+// Package batch 实现按分片（shard）拆分单个长任务的批次跟踪：提交方把一个耗时较长的 Task
+// 拆成 N 个分片 Task 各自入队执行，在 Redis 里登记一个 BatchTCB{ParentTaskID, TotalShards,
+// DoneShards, FailedShards, ShardID2TaskID, ExpiresAt} 跟踪整体进度；每个分片 Task 结束后原子
+// 地把自己计入 Done/Failed 并把结果追加到 results 列表，最后一个到达的分片负责触发父 Task
+// 的合并/终态推进（由 service 包完成，本包只管 Redis 状态本身）。本包只依赖 models + go-redis，
+// 不依赖 service（避免循环 import），Redis 客户端由调用方传入——与 service/events.go 里的
+// eventsClient 是同一个连接，和 service/pipeline、service/orchestrator 接受 *gorm.DB 而不是
+// 自己连接 DB 是同一种约定。
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"StoryToVideo-server/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+func batchKey(batchID string) string {
+	return "batch:" + batchID
+}
+
+func resultsKey(batchID string) string {
+	return "batch:results:" + batchID
+}
+
+// TCB 是 BatchTaskControlBlock 在 Go 侧的只读视图，由 Get 从 Redis hash 反序列化得到
+type TCB struct {
+	BatchID        string
+	ParentTaskID   string
+	TotalShards    int
+	DoneShards     int
+	FailedShards   int
+	ShardID2TaskID map[string]string
+	ExpiresAt      time.Time
+}
+
+// Outcome 是一次分片上报（ReportShardDone/ReportShardFailed）后批次的最新计数
+type Outcome struct {
+	DoneShards   int
+	FailedShards int
+	TotalShards  int
+}
+
+// Complete 报告该批次是否已经收齐所有分片的结果：DoneShards+FailedShards == TotalShards
+func (o Outcome) Complete() bool {
+	return o.TotalShards > 0 && o.DoneShards+o.FailedShards >= o.TotalShards
+}
+
+// Progress 按 DoneShards*100/TotalShards 换算父任务的展示进度，和 pipeline/orchestrator
+// 里各自的进度口径一致：只认已经跑完的分片，不把 failed 分片算作进度
+func (o Outcome) Progress() int {
+	if o.TotalShards == 0 {
+		return 0
+	}
+	return o.DoneShards * 100 / o.TotalShards
+}
+
+// Register 在拆分出 N 个分片任务后登记一个新批次；ttl 是整个批次允许的最长存活时间
+// （调用方约定取单分片超时的 2 倍），超过仍未收齐分片就由 service.StartBatchReaper 判定超时
+func Register(ctx context.Context, rdb *redis.Client, batchID, parentTaskID string, shardID2TaskID map[string]string, ttl time.Duration) error {
+	shardsJSON, err := json.Marshal(shardID2TaskID)
+	if err != nil {
+		return fmt.Errorf("序列化分片映射失败: %w", err)
+	}
+	key := batchKey(batchID)
+	if err := rdb.HSet(ctx, key, map[string]interface{}{
+		"parent_task_id":   parentTaskID,
+		"total_shards":     len(shardID2TaskID),
+		"done_shards":      0,
+		"failed_shards":    0,
+		"shard_id2task_id": string(shardsJSON),
+		"expires_at":       time.Now().Add(ttl).Unix(),
+	}).Err(); err != nil {
+		return fmt.Errorf("写入 BatchTCB 失败: %w", err)
+	}
+	return rdb.Expire(ctx, key, ttl).Err()
+}
+
+// Get 读出一个批次当前的状态；批次不存在（从未登记，或已经被 Cleanup）时返回 error
+func Get(ctx context.Context, rdb *redis.Client, batchID string) (*TCB, error) {
+	fields, err := rdb.HGetAll(ctx, batchKey(batchID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取 BatchTCB %s 失败: %w", batchID, err)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("batch: 批次 %s 不存在或已过期", batchID)
+	}
+
+	tcb := &TCB{BatchID: batchID, ParentTaskID: fields["parent_task_id"]}
+	tcb.TotalShards, _ = strconv.Atoi(fields["total_shards"])
+	tcb.DoneShards, _ = strconv.Atoi(fields["done_shards"])
+	tcb.FailedShards, _ = strconv.Atoi(fields["failed_shards"])
+	if ts, err := strconv.ParseInt(fields["expires_at"], 10, 64); err == nil {
+		tcb.ExpiresAt = time.Unix(ts, 0)
+	}
+	_ = json.Unmarshal([]byte(fields["shard_id2task_id"]), &tcb.ShardID2TaskID)
+	return tcb, nil
+}
+
+// incrShardScript 原子地把 field（"done_shards" 或 "failed_shards"）加一并返回递增后的
+// done/failed/total 三个计数；两个分片几乎同时上报时也不会互相踩（HINCRBY 本身原子，脚本
+// 再把三个读出来的值打包返回，保证调用方拿到的是同一个时间点的快照）
+var incrShardScript = redis.NewScript(`
+local done = tonumber(redis.call("HGET", KEYS[1], "done_shards") or "0")
+local failed = tonumber(redis.call("HGET", KEYS[1], "failed_shards") or "0")
+if ARGV[1] == "done_shards" then
+	done = redis.call("HINCRBY", KEYS[1], "done_shards", 1)
+else
+	failed = redis.call("HINCRBY", KEYS[1], "failed_shards", 1)
+end
+local total = tonumber(redis.call("HGET", KEYS[1], "total_shards") or "0")
+return {done, failed, total}
+`)
+
+func incrShard(ctx context.Context, rdb *redis.Client, batchID, field string) (Outcome, error) {
+	res, err := incrShardScript.Run(ctx, rdb, []string{batchKey(batchID)}, field).Result()
+	if err != nil {
+		return Outcome{}, fmt.Errorf("批次 %s 原子计数失败: %w", batchID, err)
+	}
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 3 {
+		return Outcome{}, fmt.Errorf("批次 %s 计数脚本返回格式异常", batchID)
+	}
+	toInt := func(v interface{}) int {
+		n, _ := v.(int64)
+		return int(n)
+	}
+	return Outcome{DoneShards: toInt(vals[0]), FailedShards: toInt(vals[1]), TotalShards: toInt(vals[2])}, nil
+}
+
+// ReportShardDone 在某个分片 Task 成功完成后调用：把它的 TaskResult 追加到
+// batch:results:<batch_id> 列表，再原子递增 DoneShards
+func ReportShardDone(ctx context.Context, rdb *redis.Client, batchID string, result models.TaskResult) (Outcome, error) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return Outcome{}, fmt.Errorf("序列化分片结果失败: %w", err)
+	}
+	if err := rdb.RPush(ctx, resultsKey(batchID), data).Err(); err != nil {
+		return Outcome{}, fmt.Errorf("追加分片结果失败: %w", err)
+	}
+	return incrShard(ctx, rdb, batchID, "done_shards")
+}
+
+// ReportShardFailed 在某个分片 Task 真正落到终态 failed（重试已耗尽，不是 retry_scheduled）
+// 后调用，原子递增 FailedShards；不追加结果，CollectResults 合并时天然跳过这个分片
+func ReportShardFailed(ctx context.Context, rdb *redis.Client, batchID string) (Outcome, error) {
+	return incrShard(ctx, rdb, batchID, "failed_shards")
+}
+
+// CollectResults 读出目前已经到达的所有分片结果（失败的分片不在其中），供合并成父任务最终
+// 结果，也供批次超时时填充 partial_results
+func CollectResults(ctx context.Context, rdb *redis.Client, batchID string) ([]models.TaskResult, error) {
+	raw, err := rdb.LRange(ctx, resultsKey(batchID), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("读取批次 %s 分片结果失败: %w", batchID, err)
+	}
+	results := make([]models.TaskResult, 0, len(raw))
+	for _, r := range raw {
+		var tr models.TaskResult
+		if err := json.Unmarshal([]byte(r), &tr); err != nil {
+			continue
+		}
+		results = append(results, tr)
+	}
+	return results, nil
+}
+
+// DecrFailedShards 把 FailedShards 减少 n，供调用方把已经重新排队的失败分片从计数里退回去
+// （它们结束时会再上报一次 ReportShardDone/ReportShardFailed），同时把批次 TTL 续到 ttl，
+// 避免 reaper 在重跑的分片还没跑完时就把批次判定为超时
+func DecrFailedShards(ctx context.Context, rdb *redis.Client, batchID string, n int, ttl time.Duration) error {
+	key := batchKey(batchID)
+	if err := rdb.HIncrBy(ctx, key, "failed_shards", int64(-n)).Err(); err != nil {
+		return fmt.Errorf("重置批次 %s 失败计数失败: %w", batchID, err)
+	}
+	if err := rdb.HSet(ctx, key, "expires_at", time.Now().Add(ttl).Unix()).Err(); err != nil {
+		return fmt.Errorf("续期批次 %s 失败: %w", batchID, err)
+	}
+	return rdb.Expire(ctx, key, ttl).Err()
+}
+
+// Cleanup 删除一个已经终结（完成，或被 reaper 判定超时）批次在 Redis 里的全部状态
+func Cleanup(ctx context.Context, rdb *redis.Client, batchID string) {
+	rdb.Del(ctx, batchKey(batchID), resultsKey(batchID))
+}
+
+// ListBatchIDs 扫描所有仍然存在于 Redis 的批次 key，供 service.StartBatchReaper 周期性
+// 检查哪些批次已经超过 ExpiresAt 还没收齐分片
+func ListBatchIDs(ctx context.Context, rdb *redis.Client) ([]string, error) {
+	var ids []string
+	iter := rdb.Scan(ctx, 0, "batch:*", 100).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if strings.HasPrefix(key, "batch:results:") {
+			continue
+		}
+		ids = append(ids, strings.TrimPrefix(key, "batch:"))
+	}
+	return ids, iter.Err()
+}
@@ -0,0 +1,330 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"StoryToVideo-server/config"
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service/batch"
+	"StoryToVideo-server/service/orchestrator"
+	"StoryToVideo-server/service/pipeline"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// defaultShardTimeout 是单个分片任务允许运行的时长，未在配置里显式指定时使用
+const defaultShardTimeout = 10 * time.Minute
+
+// shardTimeout 读取配置里的单分片超时，未配置时按 defaultShardTimeout 兜底
+func shardTimeout() time.Duration {
+	if config.AppConfig == nil || config.AppConfig.Batch.ShardTimeout == 0 {
+		return defaultShardTimeout
+	}
+	return config.AppConfig.Batch.ShardTimeout
+}
+
+// SubmitShardedTTS 把一次项目级 TTS 生成按 shot 拆成 N 个分片 Task：每个 shot 各自入队、各自
+// 独立重试，互不阻塞。父 Task 先落 processing 状态（不直接入队），等所有分片到齐后由
+// OnShardTaskFinished/OnShardTaskFailed 合并结果并推进到终态。相比旧的单个 Task 包住整条项目
+// 音轨，分片让 20 分钟 asynq 超时只约束单个 shot 而不是整个项目，WebSocket 进度也能随分片
+// 完成逐步推进，而不是卡住不动直到最后一次性跳到 100%。
+func SubmitShardedTTS(projectID string, shots []models.Shot, ttsDefaults models.TTSParams) (*models.Task, error) {
+	if len(shots) == 0 {
+		return nil, fmt.Errorf("batch: 项目 %s 没有分镜，无法按分片生成 TTS", projectID)
+	}
+
+	batchID := uuid.NewString()
+	parent := &models.Task{
+		ID:        uuid.NewString(),
+		ProjectId: projectID,
+		Type:      models.TaskTypeProjectAudio,
+		Status:    models.TaskStatusProcessing,
+		Message:   fmt.Sprintf("音频生成任务已拆分为 %d 个分片", len(shots)),
+		Parameters: models.TaskParameters{
+			TTS:     &ttsDefaults,
+			BatchID: batchID,
+		},
+	}
+	if err := models.CreateTask(parent); err != nil {
+		return nil, fmt.Errorf("创建父任务失败: %w", err)
+	}
+
+	shardID2TaskID := make(map[string]string, len(shots))
+	shardTasks := make([]*models.Task, 0, len(shots))
+	for i, shot := range shots {
+		shard := &models.Task{
+			ID:        uuid.NewString(),
+			ProjectId: projectID,
+			ShotId:    shot.ID,
+			Type:      models.TaskTypeProjectAudio,
+			Status:    models.TaskStatusPending,
+			Message:   fmt.Sprintf("TTS 分片 %d/%d", i+1, len(shots)),
+			Parameters: models.TaskParameters{
+				TTS:        &ttsDefaults,
+				Shot:       &models.ShotParams{ShotId: shot.ID},
+				BatchID:    batchID,
+				ShardIndex: i,
+			},
+		}
+		if err := models.CreateTask(shard); err != nil {
+			return nil, fmt.Errorf("创建分片任务 %d 失败: %w", i, err)
+		}
+		shardID2TaskID[strconv.Itoa(i)] = shard.ID
+		shardTasks = append(shardTasks, shard)
+	}
+
+	if err := batch.Register(context.Background(), eventsClient, batchID, parent.ID, shardID2TaskID, 2*shardTimeout()); err != nil {
+		return nil, fmt.Errorf("登记 BatchTCB 失败: %w", err)
+	}
+
+	PublishTaskUpdate(parent)
+	for _, shard := range shardTasks {
+		if err := EnqueueTask(shard.ID); err != nil {
+			log.Printf("[Batch] 分片任务 %s 入队失败: %v", shard.ID, err)
+		}
+	}
+	return parent, nil
+}
+
+// OnShardTaskFinished 在一个分片 Task 成功完成后调用：把它的结果计入批次，收齐后合并所有
+// 分片结果并推进父任务到终态；还没收齐则只把父任务的 Progress 往前挪一格。task 不是分片
+// （Parameters.BatchID 为空）时直接返回，调用方不需要预先判断。
+func OnShardTaskFinished(db *gorm.DB, task *models.Task) error {
+	if task.Parameters.BatchID == "" {
+		return nil
+	}
+	ctx := context.Background()
+	outcome, err := batch.ReportShardDone(ctx, eventsClient, task.Parameters.BatchID, task.Result)
+	if err != nil {
+		return err
+	}
+	return advanceBatch(ctx, db, task.Parameters.BatchID, task.ProjectId, outcome)
+}
+
+// OnShardTaskFailed 在一个分片 Task 真正落到终态 failed（重试已耗尽，区别于还会被
+// RetrySweeper 捡回去的 retry_scheduled）后调用，原子递增批次的 FailedShards。
+func OnShardTaskFailed(db *gorm.DB, task *models.Task) error {
+	if task.Parameters.BatchID == "" {
+		return nil
+	}
+	ctx := context.Background()
+	outcome, err := batch.ReportShardFailed(ctx, eventsClient, task.Parameters.BatchID)
+	if err != nil {
+		return err
+	}
+	return advanceBatch(ctx, db, task.Parameters.BatchID, task.ProjectId, outcome)
+}
+
+// advanceBatch 把最新进度写回父任务，收齐全部分片后转去 finalizeBatch 做合并/终态推进
+func advanceBatch(ctx context.Context, db *gorm.DB, batchID, projectID string, outcome batch.Outcome) error {
+	tcb, err := batch.Get(ctx, eventsClient, batchID)
+	if err != nil {
+		return err
+	}
+
+	if !outcome.Complete() {
+		progress := outcome.Progress()
+		if err := models.UpdateTaskStatus(tcb.ParentTaskID, models.TaskStatusProcessing, &progress, nil, nil, nil, nil, nil); err != nil {
+			log.Printf("[Batch] 更新父任务 %s 进度失败: %v", tcb.ParentTaskID, err)
+		}
+		PublishTaskEvent(TaskEvent{TaskID: tcb.ParentTaskID, ProjectID: projectID, Status: models.TaskStatusProcessing, Progress: progress})
+		return nil
+	}
+	return finalizeBatch(ctx, db, batchID, tcb, outcome)
+}
+
+// finalizeBatch 在批次收齐全部分片后调用：合并分片结果写回父任务，清理 Redis 里的批次状态，
+// 再走一遍和普通任务一样的 pipeline/orchestrator 推进收尾
+func finalizeBatch(ctx context.Context, db *gorm.DB, batchID string, tcb *batch.TCB, outcome batch.Outcome) error {
+	results, err := batch.CollectResults(ctx, eventsClient, batchID)
+	if err != nil {
+		log.Printf("[Batch] %v", err)
+	}
+	defer batch.Cleanup(ctx, eventsClient, batchID)
+
+	parent, err := models.GetTaskByIDGorm(db, tcb.ParentTaskID)
+	if err != nil {
+		return fmt.Errorf("加载父任务 %s 失败: %w", tcb.ParentTaskID, err)
+	}
+
+	if outcome.FailedShards > 0 {
+		msg := fmt.Sprintf("%d/%d 个分片失败", outcome.FailedShards, outcome.TotalShards)
+		failResult := &models.TaskResult{PartialResults: resourceURLs(results)}
+		if err := parent.UpdateStatus(db, models.TaskStatusFailed, failResult, msg); err != nil {
+			return fmt.Errorf("标记父任务 %s 失败失败: %w", parent.ID, err)
+		}
+		PublishTaskUpdate(parent)
+		if err := orchestrator.OnTaskFailed(db, parent.ID, orchestratorFailurePolicy(), PublishStatusEvent); err != nil {
+			log.Printf("orchestrator: 级联取消依赖任务失败: %v", err)
+		}
+		return nil
+	}
+
+	merged := mergeShardResults(results)
+	if err := parent.UpdateStatus(db, models.TaskStatusSuccess, merged, ""); err != nil {
+		return fmt.Errorf("标记父任务 %s 完成失败: %w", parent.ID, err)
+	}
+	PublishTaskUpdate(parent)
+	if err := pipeline.OnTaskCompleted(db, parent, EnqueueTask); err != nil {
+		log.Printf("pipeline: 推进 DAG 失败: %v", err)
+	}
+	if err := orchestrator.OnTaskFinished(db, parent.ID, EnqueueTask, PublishStatusEvent); err != nil {
+		log.Printf("orchestrator: 推进依赖任务失败: %v", err)
+	}
+	if err := SubmitModerationFollowUp(db, parent); err != nil {
+		log.Printf("moderation: 创建审核跟进任务失败: %v", err)
+	}
+	return nil
+}
+
+// mergeShardResults 把各分片各自产出的 TaskResult 合并成父任务最终的单个 TaskResult。真正的
+// 音频拼接/视频 mux/图片拼网格需要接入 ffmpeg 之类的工具链，目前 streaming 模块只做了 HLS
+// 切片打包，还没有这类合流能力——这里把所有分片的 ResourceUrl 按分片上报完成的先后顺序用逗号
+// 拼起来，保证父任务至少能拿到完整的分片清单；清单不按 shard 编号排序。
+func mergeShardResults(results []models.TaskResult) *models.TaskResult {
+	urls := resourceURLs(results)
+	merged := &models.TaskResult{ResourceUrl: joinURLs(urls)}
+	if len(results) > 0 {
+		merged.ResourceType = results[0].ResourceType
+	}
+	return merged
+}
+
+func resourceURLs(results []models.TaskResult) []string {
+	urls := make([]string, 0, len(results))
+	for _, r := range results {
+		if r.ResourceUrl != "" {
+			urls = append(urls, r.ResourceUrl)
+		}
+	}
+	return urls
+}
+
+func joinURLs(urls []string) string {
+	out := ""
+	for i, u := range urls {
+		if i > 0 {
+			out += ","
+		}
+		out += u
+	}
+	return out
+}
+
+// StartBatchReaper 周期性扫描 Redis 里所有仍然存在的批次，把已经超过 ExpiresAt 却还没收齐
+// 分片的批次判定为 stalled：父任务标记 failed，result.partial_results 指向已经到达的分片
+// 产出，配合 POST /v1/api/tasks/:task_id/retry_failed_shards 让调用方只重试缺的那些分片。
+func StartBatchReaper(db *gorm.DB, interval time.Duration) {
+	log.Printf("Starting Batch Reaper with interval %s...", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweepStalledBatches(db)
+		}
+	}()
+}
+
+func sweepStalledBatches(db *gorm.DB) {
+	ctx := context.Background()
+	ids, err := batch.ListBatchIDs(ctx, eventsClient)
+	if err != nil {
+		log.Printf("[BatchReaper] 扫描批次失败: %v", err)
+		return
+	}
+	for _, batchID := range ids {
+		tcb, err := batch.Get(ctx, eventsClient, batchID)
+		if err != nil {
+			continue
+		}
+		if time.Now().Before(tcb.ExpiresAt) {
+			continue
+		}
+		reapStalledBatch(ctx, db, batchID, tcb)
+	}
+}
+
+func reapStalledBatch(ctx context.Context, db *gorm.DB, batchID string, tcb *batch.TCB) {
+	parent, err := models.GetTaskByIDGorm(db, tcb.ParentTaskID)
+	if err != nil {
+		log.Printf("[BatchReaper] 加载父任务 %s 失败: %v", tcb.ParentTaskID, err)
+		return
+	}
+	if parent.Status != models.TaskStatusProcessing {
+		// 已经被 finalizeBatch 正常收尾（或人工取消），批次 key 只是还没来得及被 Cleanup
+		batch.Cleanup(ctx, eventsClient, batchID)
+		return
+	}
+
+	results, err := batch.CollectResults(ctx, eventsClient, batchID)
+	if err != nil {
+		log.Printf("[BatchReaper] %v", err)
+	}
+	defer batch.Cleanup(ctx, eventsClient, batchID)
+
+	pending := tcb.TotalShards - tcb.DoneShards - tcb.FailedShards
+	msg := fmt.Sprintf("批次超时：%d/%d 个分片未完成", pending, tcb.TotalShards)
+	result := &models.TaskResult{PartialResults: resourceURLs(results)}
+	if err := parent.UpdateStatus(db, models.TaskStatusFailed, result, msg); err != nil {
+		log.Printf("[BatchReaper] 标记父任务 %s 失败失败: %v", parent.ID, err)
+		return
+	}
+	PublishTaskUpdate(parent)
+	log.Printf("[BatchReaper] 批次 %s 超时，父任务 %s 标记为 failed", batchID, parent.ID)
+}
+
+// RetryFailedShards 重新入队一个分片批次里所有真正失败（TaskStatusFailed）的分片 Task，
+// 供 POST /v1/api/tasks/:task_id/retry_failed_shards 使用；parentTaskID 必须是一个
+// SubmitSharded* 创建的父任务（Parameters.BatchID 非空），否则返回 error。
+func RetryFailedShards(db *gorm.DB, parentTaskID string) ([]string, error) {
+	parent, err := models.GetTaskByIDGorm(db, parentTaskID)
+	if err != nil {
+		return nil, fmt.Errorf("任务不存在: %w", err)
+	}
+	if parent.Parameters.BatchID == "" {
+		return nil, fmt.Errorf("任务 %s 不是分片批次任务", parentTaskID)
+	}
+
+	ctx := context.Background()
+	tcb, err := batch.Get(ctx, eventsClient, parent.Parameters.BatchID)
+	if err != nil {
+		return nil, fmt.Errorf("批次已过期，无法定位分片: %w", err)
+	}
+
+	var retried []string
+	for _, shardTaskID := range tcb.ShardID2TaskID {
+		shard, err := models.GetTaskByIDGorm(db, shardTaskID)
+		if err != nil || shard.Status != models.TaskStatusFailed {
+			continue
+		}
+		if err := models.UpdateTaskStatus(shard.ID, models.TaskStatusPending, nil, nil, nil, nil, nil, nil); err != nil {
+			log.Printf("[Batch] 重置分片 %s 失败: %v", shard.ID, err)
+			continue
+		}
+		PublishTaskEvent(TaskEvent{TaskID: shard.ID, ProjectID: shard.ProjectId, Status: models.TaskStatusPending})
+		if err := EnqueueTask(shard.ID); err != nil {
+			log.Printf("[Batch] 分片 %s 入队失败: %v", shard.ID, err)
+			continue
+		}
+		retried = append(retried, shard.ID)
+	}
+	if len(retried) == 0 {
+		return retried, fmt.Errorf("没有可重试的失败分片")
+	}
+
+	// 失败分片重新变成 pending 后，批次还没结束，把 FailedShards 减掉对应数量，让
+	// OnShardTaskFinished/OnShardTaskFailed 下一次上报时能正确判断批次是否真正收齐
+	if err := batch.DecrFailedShards(ctx, eventsClient, parent.Parameters.BatchID, len(retried), 2*shardTimeout()); err != nil {
+		log.Printf("[Batch] %v", err)
+	}
+	if err := parent.UpdateStatus(db, models.TaskStatusProcessing, nil, ""); err != nil {
+		log.Printf("[Batch] 重置父任务 %s 状态失败: %v", parent.ID, err)
+	}
+	PublishTaskUpdate(parent)
+	return retried, nil
+}
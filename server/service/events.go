@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"StoryToVideo-server/config"
+	"StoryToVideo-server/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TaskEvent 是一次任务状态变更对外广播的最小负载：调用 models.UpdateTaskStatus / Task.UpdateStatus
+// 的地方提交后通过 PublishTaskEvent/PublishTaskUpdate 发出一条（两个 models 函数本身不发布），发到 task:events:<task_id>（以及 ProjectID 非空时的
+// task:events:project:<project_id>），供 TaskProgressWebSocket/未来的项目级订阅消费。
+type TaskEvent struct {
+	TaskID    string             `json:"task_id"`
+	ProjectID string             `json:"project_id,omitempty"`
+	Status    string             `json:"status"`
+	Progress  int                `json:"progress"`
+	Message   string             `json:"message"`
+	Result    *models.TaskResult `json:"result,omitempty"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+// lastEventTTL 是 task:last:<task_id> 兜底快照的保留时间：只要够覆盖一次客户端掉线重连的
+// 窗口即可，不需要和任务本身的生命周期一样长（DB 才是长期的事实来源）。
+const lastEventTTL = 5 * time.Minute
+
+var eventsClient *redis.Client
+
+// InitEvents 初始化发布/订阅用的 Redis 客户端，与 InitQueue 共用同一个 Redis 实例
+// （asynq 走自己的连接池，这里单独开一个 go-redis 客户端专门做 Pub/Sub + 兜底缓存）
+func InitEvents() {
+	eventsClient = redis.NewClient(&redis.Options{
+		Addr:     config.AppConfig.Redis.Addr,
+		Password: config.AppConfig.Redis.Password,
+	})
+}
+
+func taskEventChannel(taskID string) string {
+	return "task:events:" + taskID
+}
+
+func projectEventChannel(projectID string) string {
+	return "task:events:project:" + projectID
+}
+
+func taskLastKey(taskID string) string {
+	return "task:last:" + taskID
+}
+
+// PublishTaskEvent 把一次任务状态变更发到 task 频道，ProjectID 非空时再额外发一份到项目
+// 频道供整项目订阅；同时把这条事件写进 task:last:<task_id>（几分钟 TTL），供晚到/重连的
+// WebSocket 订阅者在错过终态事件时兜底读取。eventsClient 未初始化（例如单元测试里没跑
+// InitEvents）时直接跳过，不影响调用方的主流程。
+func PublishTaskEvent(evt TaskEvent) {
+	if eventsClient == nil {
+		return
+	}
+	if evt.UpdatedAt.IsZero() {
+		evt.UpdatedAt = time.Now()
+	}
+
+	data, err := json.Marshal(evt)
+	if err != nil {
+		log.Printf("[events] 序列化任务事件失败: %v", err)
+		return
+	}
+
+	ctx := context.Background()
+	if err := eventsClient.Publish(ctx, taskEventChannel(evt.TaskID), data).Err(); err != nil {
+		log.Printf("[events] 发布任务事件失败 task=%s: %v", evt.TaskID, err)
+	}
+	if evt.ProjectID != "" {
+		if err := eventsClient.Publish(ctx, projectEventChannel(evt.ProjectID), data).Err(); err != nil {
+			log.Printf("[events] 发布项目事件失败 project=%s: %v", evt.ProjectID, err)
+		}
+	}
+	if err := eventsClient.Set(ctx, taskLastKey(evt.TaskID), data, lastEventTTL).Err(); err != nil {
+		log.Printf("[events] 写入任务兜底快照失败 task=%s: %v", evt.TaskID, err)
+	}
+}
+
+// PublishTaskUpdate 是 PublishTaskEvent 的便捷包装：调用方已经有一份 *models.Task（例如
+// Task.UpdateStatus 走 GORM Updates 后，传入的 model 本身就会被回写成最新字段），直接从
+// 上面取字段发布，不用在每个调用点手写一遍 TaskEvent 字面量。
+func PublishTaskUpdate(t *models.Task) {
+	if t == nil {
+		return
+	}
+	result := t.Result
+	PublishTaskEvent(TaskEvent{
+		TaskID:    t.ID,
+		ProjectID: t.ProjectId,
+		Status:    t.Status,
+		Progress:  t.Progress,
+		Message:   t.Message,
+		Result:    &result,
+		UpdatedAt: t.UpdatedAt,
+	})
+}
+
+// PublishStatusEvent 是 PublishTaskEvent 的瘦包装，签名对应 pipeline.TaskEventFunc /
+// orchestrator.TaskEventFunc，供这两个不依赖 service 的包以回调形式注入广播动作。
+func PublishStatusEvent(taskID, projectID, status string) {
+	PublishTaskEvent(TaskEvent{TaskID: taskID, ProjectID: projectID, Status: status})
+}
+
+// SubscribeTaskEvents 订阅单个任务的事件流，TaskProgressWebSocket 用它替换掉原来每秒轮询
+// DB 的 ticker；调用方负责在结束时关闭返回的 *redis.PubSub。
+func SubscribeTaskEvents(ctx context.Context, taskID string) *redis.PubSub {
+	return eventsClient.Subscribe(ctx, taskEventChannel(taskID))
+}
+
+// SubscribeProjectEvents 订阅 task:events:project:<project_id>，供前端一次性关注整个项目
+// 下所有任务的进度变化，而不必为每个 task_id 各开一条 WebSocket/SSE 连接。
+func SubscribeProjectEvents(ctx context.Context, projectID string) *redis.PubSub {
+	return eventsClient.Subscribe(ctx, projectEventChannel(projectID))
+}
+
+// LastTaskEvent 读取 task:last:<task_id> 兜底快照：WebSocket 订阅者连接时优先用它做初始
+// 快照（免一次 DB 查询），命中失败（key 不存在/已过期）时调用方应该退回到查 DB。
+func LastTaskEvent(ctx context.Context, taskID string) (*TaskEvent, error) {
+	if eventsClient == nil {
+		return nil, nil
+	}
+	data, err := eventsClient.Get(ctx, taskLastKey(taskID)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var evt TaskEvent
+	if err := json.Unmarshal(data, &evt); err != nil {
+		return nil, err
+	}
+	return &evt, nil
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"StoryToVideo-server/models"
+)
+
+// JobProgress 是一次 job 进度/终态事件：来自 Worker 的 POST /v1/api/worker/callback，
+// 或对账 ticker 兜底轮询到的结果，两者都经由 JobHub.Publish 统一分发。
+type JobProgress struct {
+	JobID    string
+	Status   string
+	Progress int
+	Message  string
+	Result   *models.TaskResult
+}
+
+// jobEntry 跟踪单个 job 的最新进度：HandleGenerateTask 通过 resultCh 阻塞等待终态，
+// SSE handler 通过 subscribers 转发中间进度，对账 ticker 通过 lastUpdate 判断是否失联。
+type jobEntry struct {
+	mu          sync.Mutex
+	taskID      string
+	resultCh    chan *models.TaskResult
+	subscribers map[chan JobProgress]struct{}
+	lastUpdate  time.Time
+	lastErr     error
+}
+
+// JobHub 是进程内的 job 进度中转站，与 moderation.DefaultManager 同级的包级单例约定：
+// dispatchWorkerRequest 拿到 job_id 后 Register 登记等待者，worker 回调或对账轮询
+// Publish 进度，SSE handler Subscribe 拿同一份事件流转发给浏览器。
+type JobHub struct {
+	mu   sync.Mutex
+	jobs map[string]*jobEntry
+}
+
+func NewJobHub() *JobHub {
+	return &JobHub{jobs: make(map[string]*jobEntry)}
+}
+
+// DefaultJobHub 进程内唯一的 JobHub 实例
+var DefaultJobHub = NewJobHub()
+
+func (h *JobHub) entry(jobID string) *jobEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.jobs[jobID]
+	if !ok {
+		e = &jobEntry{
+			resultCh:    make(chan *models.TaskResult, 1),
+			subscribers: make(map[chan JobProgress]struct{}),
+			lastUpdate:  time.Now(),
+		}
+		h.jobs[jobID] = e
+	}
+	return e
+}
+
+// Register 登记等待 jobID 终态的消费者（HandleGenerateTask 在提交 Worker 请求后调用），
+// 返回阻塞接收最终 TaskResult 的 channel，以及收到终态/放弃等待后应调用的 unregister
+func (h *JobHub) Register(jobID, taskID string) (resultCh chan *models.TaskResult, unregister func()) {
+	e := h.entry(jobID)
+	e.mu.Lock()
+	e.taskID = taskID
+	e.lastUpdate = time.Now()
+	e.mu.Unlock()
+
+	return e.resultCh, func() {
+		h.mu.Lock()
+		delete(h.jobs, jobID)
+		h.mu.Unlock()
+	}
+}
+
+// Subscribe 订阅 jobID 的进度事件流（/tasks/:task_id/events 的 SSE handler 调用）
+func (h *JobHub) Subscribe(jobID string) (ch chan JobProgress, cancel func()) {
+	e := h.entry(jobID)
+	ch = make(chan JobProgress, 8)
+	e.mu.Lock()
+	e.subscribers[ch] = struct{}{}
+	e.mu.Unlock()
+
+	return ch, func() {
+		e.mu.Lock()
+		delete(e.subscribers, ch)
+		e.mu.Unlock()
+	}
+}
+
+// TaskIDFor 返回登记 jobID 时关联的 task_id，供回调 handler 把进度写回对应的 Task 行
+func (h *JobHub) TaskIDFor(jobID string) (string, bool) {
+	h.mu.Lock()
+	e, ok := h.jobs[jobID]
+	h.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.taskID, e.taskID != ""
+}
+
+// LastUpdate 返回 jobID 最近一次收到进度事件的时间，供对账 ticker 判断该 job 是否已失联
+func (h *JobHub) LastUpdate(jobID string) (time.Time, bool) {
+	h.mu.Lock()
+	e, ok := h.jobs[jobID]
+	h.mu.Unlock()
+	if !ok {
+		return time.Time{}, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastUpdate, true
+}
+
+// LastError 返回 jobID 终态为 failed 时 Publish 记录下来的错误
+func (h *JobHub) LastError(jobID string) error {
+	h.mu.Lock()
+	e, ok := h.jobs[jobID]
+	h.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lastErr
+}
+
+// Publish 写入一次进度/终态事件：未完成状态只转发给 SSE 订阅者；终态（finished/failed）
+// 额外非阻塞地推一次 resultCh，唤醒正在 waitJobResult 中阻塞等待的 goroutine
+func (h *JobHub) Publish(p JobProgress) {
+	e := h.entry(p.JobID)
+
+	e.mu.Lock()
+	e.lastUpdate = time.Now()
+	terminal := p.Status == models.TaskStatusSuccess || p.Status == models.TaskStatusFailed
+	if terminal && p.Status == models.TaskStatusFailed {
+		e.lastErr = &jobFailedError{message: p.Message}
+	}
+	subs := make([]chan JobProgress, 0, len(e.subscribers))
+	for ch := range e.subscribers {
+		subs = append(subs, ch)
+	}
+	e.mu.Unlock()
+
+	for _, ch := range subs {
+		select {
+		case ch <- p:
+		default: // 订阅者消费不过来时丢弃这条中间进度，不阻塞回调请求
+		}
+	}
+
+	if terminal {
+		select {
+		case e.resultCh <- p.Result:
+		default:
+		}
+	}
+}
+
+// NormalizeWorkerStatus 把 worker 侧可能出现的多种完成态写法（success/completed/succeeded、
+// error 等）归一化为系统内部的 Task 状态常量，供回调 handler 落库与对账轮询判断终态使用
+func NormalizeWorkerStatus(status string) string {
+	switch status {
+	case models.TaskStatusSuccess, "success", "completed", "succeeded":
+		return models.TaskStatusSuccess
+	case models.TaskStatusFailed, "error":
+		return models.TaskStatusFailed
+	case "":
+		return models.TaskStatusProcessing
+	default:
+		return status
+	}
+}
+
+type jobFailedError struct{ message string }
+
+func (e *jobFailedError) Error() string { return "worker reported failure: " + e.message }
@@ -0,0 +1,112 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// AliyunGreen 对接阿里云内容安全（Green）。文本/图片走同步检测接口，直接返回 Result；
+// 视频帧批量审核和单资源提交都是异步任务，提交后只拿到 TaskId（这里统一叫 batchID），
+// 真正的结果由阿里云异步回调到 POST /v1/api/moderation/callback。
+type AliyunGreen struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	client    *http.Client
+}
+
+func NewAliyunGreen(endpoint, accessKey, secretKey string) *AliyunGreen {
+	return &AliyunGreen{
+		Endpoint:  endpoint,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		client:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (a *AliyunGreen) ModerateText(ctx context.Context, text string) (*Result, error) {
+	var resp aliyunSyncResponse
+	if err := a.call(ctx, "/green/text/scan", map[string]interface{}{"content": text}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.toResult(), nil
+}
+
+func (a *AliyunGreen) ModerateImage(ctx context.Context, imageURL string) (*Result, error) {
+	var resp aliyunSyncResponse
+	if err := a.call(ctx, "/green/image/scan", map[string]interface{}{"url": imageURL}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.toResult(), nil
+}
+
+func (a *AliyunGreen) SubmitVideoFrames(ctx context.Context, shotID, videoURL string, frameURLs map[string]string) (string, error) {
+	var resp aliyunAsyncResponse
+	body := map[string]interface{}{"shot_id": shotID, "video_url": videoURL, "frames": frameURLs}
+	if err := a.call(ctx, "/green/video/submit", body, &resp); err != nil {
+		return "", err
+	}
+	return resp.TaskID, nil
+}
+
+func (a *AliyunGreen) SubmitAsset(ctx context.Context, kind, resourceURL string) (*Result, string, error) {
+	var resp aliyunAsyncResponse
+	body := map[string]interface{}{"kind": kind, "url": resourceURL}
+	if err := a.call(ctx, "/green/asset/submit", body, &resp); err != nil {
+		return nil, "", err
+	}
+	return nil, resp.TaskID, nil
+}
+
+// aliyunSyncResponse 是文本/图片同步检测接口的返回（字段名按阿里云 Green 实际响应精简）
+type aliyunSyncResponse struct {
+	Suggestion string            `json:"suggestion"` // pass | review | block
+	Scores     CategoryScores    `json:"scores"`
+	Details    []aliyunDetailHit `json:"details"`
+}
+
+type aliyunDetailHit struct {
+	Label string  `json:"label"`
+	Rate  float64 `json:"rate"`
+}
+
+func (r aliyunSyncResponse) toResult() *Result {
+	remarks := make([]Remark, 0, len(r.Details))
+	for _, d := range r.Details {
+		remarks = append(remarks, Remark{Category: d.Label, Score: d.Rate})
+	}
+	return &Result{Passed: r.Suggestion == "pass", Scores: r.Scores, Remarks: remarks}
+}
+
+// aliyunAsyncResponse 是视频/单资源异步提交接口的返回
+type aliyunAsyncResponse struct {
+	TaskID string `json:"task_id"`
+}
+
+func (a *AliyunGreen) call(ctx context.Context, path string, body interface{}, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("序列化审核请求失败: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("创建审核请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Access-Key", a.AccessKey)
+	req.Header.Set("X-Secret-Key", a.SecretKey)
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("调用阿里云内容安全失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("阿里云内容安全返回非 200: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
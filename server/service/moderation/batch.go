@@ -0,0 +1,122 @@
+package moderation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ShotTCB 记录一个视频批次中单帧的审核状态
+type ShotTCB struct {
+	FrameID  string
+	FrameURL string
+	Arrived  bool
+	Result   *Result
+}
+
+// BatchTaskControlBlock 跟踪一次“批量帧审核”的整体进度：Arrived == Total 时才终结，
+// 调用方据此决定父资源（Shot）是放行还是拒绝。Kind/FinalURL/ShotID 由提交方（processor
+// 或未来的回调handler）按需填充，用于在批次完成时定位该最终落哪个 Shot 字段。
+type BatchTaskControlBlock struct {
+	BatchID      string
+	ParentTaskID string
+	ShotID       string
+	Kind         string // "image" | "video"
+	FinalURL     string
+	Frames       map[string]*ShotTCB // frameID -> tcb
+	Total        int
+	Arrived      int
+	CreatedAt    time.Time
+
+	mu sync.Mutex
+}
+
+// Done 返回该批次是否所有帧都已经到达结果
+func (b *BatchTaskControlBlock) Done() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.Arrived >= b.Total
+}
+
+// Rejected 返回目前为止所有未通过审核的帧命中详情
+func (b *BatchTaskControlBlock) Rejected() []Remark {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var remarks []Remark
+	for _, tcb := range b.Frames {
+		if tcb.Result != nil && !tcb.Result.Passed {
+			remarks = append(remarks, tcb.Result.Remarks...)
+		}
+	}
+	return remarks
+}
+
+// manager 维护进行中/刚完成的批次，key 为 batchId。完成后的批次不会立即清理——
+// 由调用方读取终态后自行决定何时 Complete（或留给后续的批次回收 sweeper）。
+type manager struct {
+	mu      sync.Mutex
+	batches map[string]*BatchTaskControlBlock
+}
+
+// DefaultManager 是进程内唯一的 BTCB 管理器（与 service.pollCancelRegistry 同级的
+// 包级单例约定），回调 handler 与同步实现（mock/local_nsfw）的 SubmitVideoFrames 都通过它
+// 读写批次状态。
+var DefaultManager = &manager{batches: make(map[string]*BatchTaskControlBlock)}
+
+// RegisterBatch 在提交审核请求后登记一个新批次
+func (m *manager) RegisterBatch(batchID, parentTaskID, shotID string, frameURLs map[string]string) *BatchTaskControlBlock {
+	btcb := &BatchTaskControlBlock{
+		BatchID:      batchID,
+		ParentTaskID: parentTaskID,
+		ShotID:       shotID,
+		Frames:       make(map[string]*ShotTCB, len(frameURLs)),
+		Total:        len(frameURLs),
+		CreatedAt:    time.Now(),
+	}
+	for frameID, url := range frameURLs {
+		btcb.Frames[frameID] = &ShotTCB{FrameID: frameID, FrameURL: url}
+	}
+	m.mu.Lock()
+	m.batches[batchID] = btcb
+	m.mu.Unlock()
+	return btcb
+}
+
+// Get 返回登记过的批次，不存在则 ok=false
+func (m *manager) Get(batchID string) (*BatchTaskControlBlock, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	btcb, ok := m.batches[batchID]
+	return btcb, ok
+}
+
+// Complete 从管理器中移除一个已经被调用方消费完终态的批次
+func (m *manager) Complete(batchID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.batches, batchID)
+}
+
+// SubmitFrameResult 回填一帧的审核结果；当 arrived == total 时返回 done=true，
+// 调用方应据此决定是放行（promote）还是拒绝（reject）整个批次，随后调用 Complete。
+func SubmitFrameResult(batchID, frameID string, result *Result) (btcb *BatchTaskControlBlock, done bool, err error) {
+	btcb, ok := DefaultManager.Get(batchID)
+	if !ok {
+		return nil, false, fmt.Errorf("moderation: unknown batch %s", batchID)
+	}
+	btcb.mu.Lock()
+	tcb, ok := btcb.Frames[frameID]
+	if !ok {
+		btcb.mu.Unlock()
+		return nil, false, fmt.Errorf("moderation: unknown frame %s in batch %s", frameID, batchID)
+	}
+	if !tcb.Arrived {
+		tcb.Arrived = true
+		btcb.Arrived++
+	}
+	tcb.Result = result
+	done = btcb.Arrived >= btcb.Total
+	btcb.mu.Unlock()
+
+	return btcb, done, nil
+}
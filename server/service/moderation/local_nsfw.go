@@ -0,0 +1,99 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// LocalNSFW 对接自建的本地 NSFW 图像分类器（HTTP 服务），只识别色情/暴露内容，不覆盖
+// 暴恐/涉政等类目。分类器本身是同步的，所以这里的所有接口都直接返回结果，不走
+// POST /v1/api/moderation/callback 异步回填；文本没有对应的分类模型，直接放行。
+type LocalNSFW struct {
+	Endpoint string
+	// Threshold 是判定为违规的分数下限（0~100），未配置时使用 defaultNSFWThreshold
+	Threshold float64
+	client    *http.Client
+}
+
+const defaultNSFWThreshold = 80
+
+func NewLocalNSFW(endpoint string, threshold float64) *LocalNSFW {
+	if threshold <= 0 {
+		threshold = defaultNSFWThreshold
+	}
+	return &LocalNSFW{Endpoint: endpoint, Threshold: threshold, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *LocalNSFW) ModerateText(ctx context.Context, text string) (*Result, error) {
+	return &Result{Passed: true}, nil
+}
+
+func (n *LocalNSFW) ModerateImage(ctx context.Context, imageURL string) (*Result, error) {
+	return n.classify(ctx, imageURL)
+}
+
+func (n *LocalNSFW) SubmitVideoFrames(ctx context.Context, shotID, videoURL string, frameURLs map[string]string) (string, error) {
+	batchID := fmt.Sprintf("local-nsfw-%s", shotID)
+	DefaultManager.RegisterBatch(batchID, "", shotID, frameURLs)
+	for frameID, frameURL := range frameURLs {
+		result, err := n.classify(ctx, frameURL)
+		if err != nil {
+			result = &Result{Passed: false, Remarks: []Remark{{Category: "other", Detail: err.Error(), FrameID: frameID}}}
+		}
+		_, _, _ = SubmitFrameResult(batchID, frameID, result)
+	}
+	return batchID, nil
+}
+
+// SubmitAsset 图片直接分类；视频/音频分类器还没有对应模型，先放行，留给以后接入专门的
+// 音频/视频审核模型时再扩展
+func (n *LocalNSFW) SubmitAsset(ctx context.Context, kind, resourceURL string) (*Result, string, error) {
+	if kind != "image" {
+		return &Result{Passed: true}, "", nil
+	}
+	result, err := n.classify(ctx, resourceURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return result, "", nil
+}
+
+type nsfwClassifyResponse struct {
+	Score float64 `json:"score"`
+}
+
+func (n *LocalNSFW) classify(ctx context.Context, resourceURL string) (*Result, error) {
+	payload, err := json.Marshal(map[string]string{"url": resourceURL})
+	if err != nil {
+		return nil, fmt.Errorf("序列化分类请求失败: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.Endpoint+"/classify", bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("创建分类请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("调用本地 NSFW 分类器失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("本地 NSFW 分类器返回非 200: %d", resp.StatusCode)
+	}
+	var out nsfwClassifyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("解析分类结果失败: %w", err)
+	}
+
+	passed := out.Score < n.Threshold
+	result := &Result{Passed: passed, Scores: CategoryScores{Porn: out.Score}}
+	if !passed {
+		result.Remarks = []Remark{{Category: "porn", Score: out.Score}}
+	}
+	return result, nil
+}
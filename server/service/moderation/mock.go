@@ -0,0 +1,37 @@
+package moderation
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// ModerationMock 是测试/本地开发使用的直通实现：文本和图片永远通过；视频批次提交后
+// 立即（同步）把每一帧都标记为通过，方便在没有真实审核供应商时跑通整条流水线。
+type ModerationMock struct{}
+
+func NewModerationMock() *ModerationMock {
+	return &ModerationMock{}
+}
+
+func (m *ModerationMock) ModerateText(ctx context.Context, text string) (*Result, error) {
+	return &Result{Passed: true}, nil
+}
+
+func (m *ModerationMock) ModerateImage(ctx context.Context, imageURL string) (*Result, error) {
+	return &Result{Passed: true}, nil
+}
+
+func (m *ModerationMock) SubmitVideoFrames(ctx context.Context, shotID, videoURL string, frameURLs map[string]string) (string, error) {
+	batchID := uuid.NewString()
+	DefaultManager.RegisterBatch(batchID, "", shotID, frameURLs)
+	for frameID := range frameURLs {
+		_, _, _ = SubmitFrameResult(batchID, frameID, &Result{Passed: true})
+	}
+	return batchID, nil
+}
+
+// SubmitAsset 直通放行：本地开发/未配置真实供应商时，资源立即判定通过，不需要等待回调
+func (m *ModerationMock) SubmitAsset(ctx context.Context, kind, resourceURL string) (*Result, string, error) {
+	return &Result{Passed: true}, "", nil
+}
@@ -0,0 +1,53 @@
+// Package moderation 提供生成内容（分镜描述/TTS文案/关键帧图片/成片视频）在落库或
+// 对外暴露（MinIO 预签名 URL）之前的内容安全审核能力。
+package moderation
+
+import (
+	"context"
+	"fmt"
+)
+
+// CategoryScores 对应各风控类目的命中分数（0~100，越高越可能违规）
+type CategoryScores struct {
+	Porn      float64 `json:"porn"`
+	Violence  float64 `json:"violence"`
+	Political float64 `json:"political"`
+	Other     float64 `json:"other,omitempty"`
+}
+
+// Remark 记录单条审核命中详情，便于回溯具体哪一类/哪一帧未通过
+type Remark struct {
+	Category string  `json:"category"`
+	Score    float64 `json:"score"`
+	Detail   string  `json:"detail,omitempty"`
+	FrameID  string  `json:"frame_id,omitempty"`
+}
+
+// Result 单次审核（一段文本 / 一张图片 / 一帧视频）的结果
+type Result struct {
+	Passed  bool           `json:"passed"`
+	Scores  CategoryScores `json:"scores"`
+	Remarks []Remark       `json:"remarks,omitempty"`
+}
+
+// Moderator 是可插拔的内容审核提供方接口。文本审核（分镜描述/TTS文案）要求同步返回结果；
+// 视觉审核（图片/视频采样帧）允许异步：实现可以直接返回结果，也可以仅返回 batchId，
+// 之后通过 POST /v1/api/moderation/callback 异步回填结果（见 batch.go）。
+type Moderator interface {
+	// ModerateText 同步审核一段文本（分镜 prompt/description、TTS 文案）
+	ModerateText(ctx context.Context, text string) (*Result, error)
+	// ModerateImage 同步审核一张图片
+	ModerateImage(ctx context.Context, imageURL string) (*Result, error)
+	// SubmitVideoFrames 提交一批视频采样帧供审核，返回供应商侧的 batchId。
+	// 同步实现可以立即通过 DefaultManager 回填结果后再返回；异步实现仅提交并返回 batchId，
+	// 结果经由 callback 接口回填。shotID 用于登记 BatchTaskControlBlock，便于回调时定位。
+	SubmitVideoFrames(ctx context.Context, shotID, videoURL string, frameURLs map[string]string) (batchID string, err error)
+	// SubmitAsset 提交单个生成产物（kind 为 "image"|"audio"|"video"，resourceURL 指向 MinIO
+	// 里的预签名地址）供审核，是 TaskTypeModeration 跟进任务统一走的入口。同步实现（mock）可以
+	// 立即返回 result，batchID 留空；异步实现只返回 batchID，result 为 nil，真正结果经
+	// POST /v1/api/moderation/callback 回填到对应的 models.ModerationRecord。
+	SubmitAsset(ctx context.Context, kind, resourceURL string) (result *Result, batchID string, err error)
+}
+
+// ErrUnsupportedKind 在调用方传入了 provider 不支持的内容类型时返回
+var ErrUnsupportedKind = fmt.Errorf("moderation: unsupported content kind")
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service/moderation"
+	"StoryToVideo-server/service/orchestrator"
+	"StoryToVideo-server/service/pipeline"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// moderatedTaskTypes 是完成后需要自动创建审核跟进任务的资源任务类型
+var moderatedTaskTypes = map[string]bool{
+	models.TaskTypeShotImage:    true,
+	models.TaskTypeProjectAudio: true,
+	models.TaskTypeVideoGen:     true,
+}
+
+// SubmitModerationFollowUp 在一个资源任务（generate_shot/generate_audio/generate_video）成功
+// 完成且写出了 ResourceUrl 后调用：创建一个 depends_on 该任务的 TaskTypeModeration 跟进任务。
+// 调用时刻资源任务已经落成 TaskStatusSuccess，orchestrator.Submit 检查依赖时直接满足，会把
+// 跟进任务立即入队，不需要等 OnTaskFinished 再解锁。非受审类型或没有产出资源时直接跳过。
+func SubmitModerationFollowUp(db *gorm.DB, task *models.Task) error {
+	if !moderatedTaskTypes[task.Type] || task.Result.ResourceUrl == "" {
+		return nil
+	}
+	modTask := &models.Task{
+		ID:        uuid.NewString(),
+		ProjectId: task.ProjectId,
+		ShotId:    task.ShotId,
+		Type:      models.TaskTypeModeration,
+		Status:    models.TaskStatusPending,
+		Message:   "内容审核任务已创建",
+		Parameters: models.TaskParameters{
+			DependsOn: []string{task.ID},
+		},
+	}
+	if err := orchestrator.Submit(db, modTask, EnqueueTask); err != nil {
+		return fmt.Errorf("创建审核任务失败: %w", err)
+	}
+	PublishTaskUpdate(modTask)
+	return nil
+}
+
+// moderationKind 把资源任务类型映射成 moderation.Moderator.SubmitAsset 认识的 kind
+func moderationKind(taskType string) string {
+	switch taskType {
+	case models.TaskTypeShotImage:
+		return "image"
+	case models.TaskTypeProjectAudio:
+		return "audio"
+	case models.TaskTypeVideoGen:
+		return "video"
+	default:
+		return "other"
+	}
+}
+
+// moderationObjectName 还原资源任务在 MinIO 里的对象名，和 processor.go 里
+// handleImageResult/handleTTSResult/handleVideoResult 写入时用的命名规则保持一致，
+// 供 ApplyModerationResult 在 blocked 时删除对象
+func moderationObjectName(taskType, shotID string) string {
+	switch taskType {
+	case models.TaskTypeShotImage:
+		return fmt.Sprintf("shots/%s/image.png", shotID)
+	case models.TaskTypeProjectAudio:
+		return fmt.Sprintf("shots/%s/audio.mp3", shotID)
+	case models.TaskTypeVideoGen:
+		return fmt.Sprintf("shots/%s/video.mp4", shotID)
+	default:
+		return ""
+	}
+}
+
+// handleModerationTask 是 TaskTypeModeration 任务的处理逻辑：不走通用的
+// dispatchWorkerRequest/轮询流程（审核供应商不是生成 worker），而是直接提交给
+// p.Moderator。同步供应商（mock）立即拿到结果、当场收尾；异步供应商只登记
+// models.ModerationRecord 并让任务留在 processing，等 POST /v1/api/moderation/callback 推进。
+func (p *Processor) handleModerationTask(task *models.Task) error {
+	if len(task.Parameters.DependsOn) == 0 {
+		return fmt.Errorf("审核任务 %s 缺少 depends_on", task.ID)
+	}
+	target, err := models.GetTaskByIDGorm(p.DB, task.Parameters.DependsOn[0])
+	if err != nil {
+		return fmt.Errorf("加载待审核任务失败: %w", err)
+	}
+	if target.Result.ResourceUrl == "" {
+		return fmt.Errorf("任务 %s 没有可供审核的资源", target.ID)
+	}
+
+	result, batchID, err := p.Moderator.SubmitAsset(context.Background(), moderationKind(target.Type), target.Result.ResourceUrl)
+	if err != nil {
+		return fmt.Errorf("提交内容审核失败: %w", err)
+	}
+
+	record := &models.ModerationRecord{
+		ID:               uuid.NewString(),
+		TaskID:           target.ID,
+		ModerationTaskID: task.ID,
+		ShotID:           target.ShotId,
+		BatchID:          batchID,
+		Status:           models.ModerationStatusWaiting,
+	}
+	if result != nil {
+		record.Status = moderationStatusFromResult(result)
+		record.Remarks = toModerationRemarks(result.Remarks)
+	}
+	if err := models.CreateModerationRecord(p.DB, record); err != nil {
+		return fmt.Errorf("登记审核记录失败: %w", err)
+	}
+
+	if result == nil {
+		// 异步供应商：任务保持 processing，真正的收尾交给 ApplyModerationResult
+		return nil
+	}
+	return ApplyModerationResult(p.DB, record, task)
+}
+
+// moderationStatusFromResult 把同步供应商直接返回的 Result 换算成 ModerationRecord.Status；
+// 本地 NSFW/mock 这类只区分"过/不过"的实现没有 manual_review 的概念
+func moderationStatusFromResult(result *moderation.Result) string {
+	if result.Passed {
+		return models.ModerationStatusPassed
+	}
+	return models.ModerationStatusBlocked
+}
+
+// ApplyModerationResult 在一条 ModerationRecord 收到终态结果后调用（同步供应商立即调用，
+// 异步供应商由 POST /v1/api/moderation/callback 调用）：把审核任务本身标记完成；
+// blocked 时额外把被审核的资源任务 flip 成 TaskStatusBlockedByModeration 并删除 MinIO 对象，
+// manual_review 不改动资源任务状态，只等运营后台人工确认。
+func ApplyModerationResult(db *gorm.DB, record *models.ModerationRecord, modTask *models.Task) error {
+	if modTask == nil {
+		var err error
+		modTask, err = models.GetTaskByIDGorm(db, record.ModerationTaskID)
+		if err != nil {
+			return fmt.Errorf("加载审核任务 %s 失败: %w", record.ModerationTaskID, err)
+		}
+	}
+
+	switch record.Status {
+	case models.ModerationStatusBlocked:
+		target, err := models.GetTaskByIDGorm(db, record.TaskID)
+		if err != nil {
+			return fmt.Errorf("加载被审核任务 %s 失败: %w", record.TaskID, err)
+		}
+		if err := models.UpdateTaskStatus(target.ID, models.TaskStatusBlockedByModeration, nil, nil, nil, nil, nil, nil); err != nil {
+			return fmt.Errorf("标记任务 %s 被审核拦截失败: %w", target.ID, err)
+		}
+		PublishTaskEvent(TaskEvent{TaskID: target.ID, ProjectID: target.ProjectId, Status: models.TaskStatusBlockedByModeration, Message: "内容未通过审核"})
+		if objectName := moderationObjectName(target.Type, target.ShotId); objectName != "" {
+			if err := DeleteObject(objectName); err != nil {
+				log.Printf("[Moderation] 删除被拦截资源 %s 失败: %v", objectName, err)
+			}
+		}
+		if err := modTask.UpdateStatus(db, models.TaskStatusSuccess, nil, "内容未通过审核，已拦截"); err != nil {
+			return fmt.Errorf("标记审核任务 %s 完成失败: %w", modTask.ID, err)
+		}
+
+	case models.ModerationStatusManualReview:
+		if err := modTask.UpdateStatus(db, models.TaskStatusSuccess, nil, "审核结果不确定，等待人工复核"); err != nil {
+			return fmt.Errorf("标记审核任务 %s 完成失败: %w", modTask.ID, err)
+		}
+
+	default: // passed
+		if err := modTask.UpdateStatus(db, models.TaskStatusSuccess, nil, "内容审核通过"); err != nil {
+			return fmt.Errorf("标记审核任务 %s 完成失败: %w", modTask.ID, err)
+		}
+	}
+
+	PublishTaskUpdate(modTask)
+	if err := pipeline.OnTaskCompleted(db, modTask, EnqueueTask); err != nil {
+		log.Printf("pipeline: 推进 DAG 失败: %v", err)
+	}
+	if err := orchestrator.OnTaskFinished(db, modTask.ID, EnqueueTask, PublishStatusEvent); err != nil {
+		log.Printf("orchestrator: 推进依赖任务失败: %v", err)
+	}
+	return nil
+}
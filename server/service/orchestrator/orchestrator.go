@@ -0,0 +1,172 @@
+// Package orchestrator 让 TaskParameters.DependsOn 真正生效：CreateTask 时依赖未满足的任务
+// 写成 blocked 且不入队；某个任务进入 finished 后扫描依赖它的子任务，全部满足的翻成 pending
+// 并入队；父任务 failed/cancelled 时按调用方配置的策略决定是否级联取消子任务。本包只依赖
+// models，不依赖 service，入队和事件广播动作通过调用方传入的回调完成（service 包反过来依赖
+// 本包，直接依赖会形成循环 import），与 service/pipeline 的约定一致。
+package orchestrator
+
+import (
+	"fmt"
+	"log"
+
+	"StoryToVideo-server/models"
+
+	"gorm.io/gorm"
+)
+
+// EnqueueFunc 由调用方注入（通常是 service.EnqueueTask），避免本包直接依赖 service
+type EnqueueFunc func(taskID string) error
+
+// TaskEventFunc 由调用方注入（通常是 service.PublishTaskEvent 的瘦包装），用于把本包做的状态
+// 翻转广播出去；调用方不关心广播时传 nil。
+type TaskEventFunc func(taskID, projectID, status string)
+
+// 父任务失败/取消时对子任务的处理策略，对应 config.AppConfig.Orchestrator.FailurePolicy。
+const (
+	// FailurePolicyCascadeCancel 把所有（递归）依赖失败任务、还没开始跑的子任务标记 cancelled
+	FailurePolicyCascadeCancel = "cascade_cancel"
+	// FailurePolicySkipAndContinue 什么都不做，子任务继续留在 blocked，等父任务被人工 retry 成功后自然解锁
+	FailurePolicySkipAndContinue = "skip_and_continue"
+)
+
+// Submit 是 models.CreateTask + EnqueueFunc 的替代入口：根据 task.Parameters.DependsOn 决定
+// 任务创建后的初始状态——依赖未全部 finished 时写成 TaskStatusBlocked 且不入队，留给
+// OnTaskFinished 或 ResumeBlockedTasks 在依赖满足时解锁；依赖已满足（或没有依赖）时正常入队。
+func Submit(db *gorm.DB, task *models.Task, enqueue EnqueueFunc) error {
+	if len(task.Parameters.DependsOn) > 0 {
+		ok, err := models.DependenciesSatisfied(db, task.Parameters.DependsOn)
+		if err != nil {
+			return fmt.Errorf("检查任务依赖失败: %w", err)
+		}
+		if !ok {
+			task.Status = models.TaskStatusBlocked
+		}
+	}
+	if err := models.CreateTask(task); err != nil {
+		return err
+	}
+	if task.Status == models.TaskStatusBlocked {
+		return nil
+	}
+	return enqueue(task.ID)
+}
+
+// OnTaskFinished 在某个任务落到 TaskStatusSuccess 后调用：扫描 Parameters.DependsOn 里引用了
+// 它的子任务，依赖全部满足的翻成 pending 并入队，实现"上一阶段一完成就推进下一阶段"而不必等
+// service/scheduler 的周期性扫描。
+func OnTaskFinished(db *gorm.DB, finishedTaskID string, enqueue EnqueueFunc, onTaskEvent TaskEventFunc) error {
+	children, err := models.GetTasksDependingOn(finishedTaskID)
+	if err != nil {
+		return fmt.Errorf("查询依赖 %s 的子任务失败: %w", finishedTaskID, err)
+	}
+	for _, child := range children {
+		if child.Status != models.TaskStatusBlocked {
+			continue
+		}
+		unlockAndEnqueue(db, child, enqueue, onTaskEvent)
+	}
+	return nil
+}
+
+func unlockAndEnqueue(db *gorm.DB, child models.Task, enqueue EnqueueFunc, onTaskEvent TaskEventFunc) {
+	ok, err := models.DependenciesSatisfied(db, child.Parameters.DependsOn)
+	if err != nil {
+		log.Printf("orchestrator: 检查任务 %s 依赖失败: %v", child.ID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+	if err := models.UpdateTaskStatus(child.ID, models.TaskStatusPending, nil, nil, nil, nil, nil, nil); err != nil {
+		log.Printf("orchestrator: 解锁任务 %s 失败: %v", child.ID, err)
+		return
+	}
+	if onTaskEvent != nil {
+		onTaskEvent(child.ID, child.ProjectId, models.TaskStatusPending)
+	}
+	if err := enqueue(child.ID); err != nil {
+		log.Printf("orchestrator: 任务 %s 入队失败: %v", child.ID, err)
+	}
+}
+
+// OnTaskFailed 在某个任务被标记失败后调用，按 failurePolicy 决定是否级联取消依赖它、尚未开始
+// 跑的子任务；failurePolicy 为空或 FailurePolicySkipAndContinue 时不做任何事。调用方在
+// Task.UpdateStatus(..., TaskStatusFailed, ...) 之后总是无条件调用这里，但该方法可能按
+// Attempts/MaxAttempts 把状态悄悄改判为 retry_scheduled（见 Task.failureUpdates），这种情况下
+// RetrySweeper 还会再捡回去重跑，不该现在就级联取消下游——所以这里重新加载一次任务，确认它
+// 确实落到了 failed/cancelled 终态再级联。
+func OnTaskFailed(db *gorm.DB, failedTaskID, failurePolicy string, onTaskEvent TaskEventFunc) error {
+	if failurePolicy != FailurePolicyCascadeCancel {
+		return nil
+	}
+	task, err := models.GetTaskByIDGorm(db, failedTaskID)
+	if err != nil {
+		return fmt.Errorf("重新加载任务 %s 失败: %w", failedTaskID, err)
+	}
+	if task.Status != models.TaskStatusFailed && task.Status != models.TaskStatusCancelled {
+		return nil // 被改判为 retry_scheduled，留给 RetrySweeper 重跑，不级联取消下游
+	}
+	return cascadeCancel(db, failedTaskID, onTaskEvent, make(map[string]bool))
+}
+
+func cascadeCancel(db *gorm.DB, parentID string, onTaskEvent TaskEventFunc, visited map[string]bool) error {
+	if visited[parentID] {
+		return nil
+	}
+	visited[parentID] = true
+
+	children, err := models.GetTasksDependingOn(parentID)
+	if err != nil {
+		return fmt.Errorf("查询依赖 %s 的子任务失败: %w", parentID, err)
+	}
+	for _, child := range children {
+		if child.Status != models.TaskStatusBlocked && child.Status != models.TaskStatusPending {
+			continue // 已经在跑/已经结束的任务不回滚，只拦截尚未开始的
+		}
+		msg := "cancelled because dependency " + parentID + " failed"
+		if err := models.UpdateTaskStatus(child.ID, models.TaskStatusCancelled, nil, &msg, nil, nil, nil, nil); err != nil {
+			log.Printf("orchestrator: 级联取消任务 %s 失败: %v", child.ID, err)
+			continue
+		}
+		if onTaskEvent != nil {
+			onTaskEvent(child.ID, child.ProjectId, models.TaskStatusCancelled)
+		}
+		if err := cascadeCancel(db, child.ID, onTaskEvent, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResumeBlockedTasks 在进程启动时重新扫描所有 blocked 任务：如果依赖其实已经满足（例如上次
+// 崩溃前父任务已经 finished，只是还没来得及解锁子任务），翻成 pending 并入队，让被中断的
+// pipeline 能继续跑完而不需要人工介入。
+func ResumeBlockedTasks(db *gorm.DB, enqueue EnqueueFunc, onTaskEvent TaskEventFunc) error {
+	var blocked []models.Task
+	if err := db.Where("status = ?", models.TaskStatusBlocked).Find(&blocked).Error; err != nil {
+		return fmt.Errorf("查询 blocked 任务失败: %w", err)
+	}
+	for _, t := range blocked {
+		ok, err := models.DependenciesSatisfied(db, t.Parameters.DependsOn)
+		if err != nil {
+			log.Printf("orchestrator: 检查任务 %s 依赖失败: %v", t.ID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := models.UpdateTaskStatus(t.ID, models.TaskStatusPending, nil, nil, nil, nil, nil, nil); err != nil {
+			log.Printf("orchestrator: 恢复任务 %s 失败: %v", t.ID, err)
+			continue
+		}
+		if onTaskEvent != nil {
+			onTaskEvent(t.ID, t.ProjectId, models.TaskStatusPending)
+		}
+		if err := enqueue(t.ID); err != nil {
+			log.Printf("orchestrator: 任务 %s 入队失败: %v", t.ID, err)
+			continue
+		}
+		log.Printf("orchestrator: 启动时恢复 blocked 任务 %s -> pending", t.ID)
+	}
+	return nil
+}
@@ -1,15 +1,23 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/rand"
 	"net/url"
+	"os"
 	"path/filepath"
 	"time"
 
 	"StoryToVideo-server/config"
+	"StoryToVideo-server/models"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
@@ -28,7 +36,7 @@ func InitMinIO() {
 	if err != nil {
 		log.Fatalf("MinIO 初始化失败: %v", err)
 	}
-    log.Println("MinIO 连接成功")
+	log.Println("MinIO 连接成功")
 }
 
 // UploadVideo 上传本地视频文件到 MinIO，返回可访问的 URL
@@ -37,17 +45,17 @@ func UploadVideo(localPath string, taskID string) (string, error) {
 	cfg := config.AppConfig.MinIO
 	bucketName := cfg.Bucket
 
-    // 自动创建 Bucket
+	// 自动创建 Bucket
 	exists, err := MinioClient.BucketExists(ctx, bucketName)
 	if err == nil && !exists {
 		MinioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{})
 	}
 
-    // 生成云端文件名，例如: tasks/123-abc/output.mp4
+	// 生成云端文件名，例如: tasks/123-abc/output.mp4
 	objectName := fmt.Sprintf("tasks/%s/%s", taskID, filepath.Base(localPath))
 	contentType := "video/mp4"
 
-    // 执行上传
+	// 执行上传
 	_, err = MinioClient.FPutObject(ctx, bucketName, objectName, localPath, minio.PutObjectOptions{
 		ContentType: contentType,
 	})
@@ -57,15 +65,15 @@ func UploadVideo(localPath string, taskID string) (string, error) {
 
 	expiry := time.Hour * 24
 	reqParams := make(url.Values)
-    // 如果需要强制下载
-    // reqParams.Set("response-content-disposition", "attachment; filename=\""+filepath.Base(localPath)+"\"")
+	// 如果需要强制下载
+	// reqParams.Set("response-content-disposition", "attachment; filename=\""+filepath.Base(localPath)+"\"")
 
-    presignedURL, err := MinioClient.PresignedGetObject(ctx, bucketName, objectName, expiry, reqParams)
-    if err != nil {
-        return "", fmt.Errorf("生成签名 URL 失败: %w", err)
-    }
+	presignedURL, err := MinioClient.PresignedGetObject(ctx, bucketName, objectName, expiry, reqParams)
+	if err != nil {
+		return "", fmt.Errorf("生成签名 URL 失败: %w", err)
+	}
 
-    return presignedURL.String(), nil // 修改这里：返回 presignedURL.String()
+	return presignedURL.String(), nil // 修改这里：返回 presignedURL.String()
 }
 
 // UploadToMinIO 通用上传函数，从 io.Reader 上传到 MinIO，返回可访问的 URL
@@ -120,7 +128,7 @@ func UploadToMinIO(reader io.Reader, objectName string, size int64) (string, err
 	// 生成预签名 URL（24小时有效期）
 	expiry := time.Hour * 72
 	reqParams := make(url.Values)
-	
+
 	presignedURL, err := MinioClient.PresignedGetObject(ctx, bucketName, objectName, expiry, reqParams)
 	if err != nil {
 		return "", fmt.Errorf("生成签名 URL 失败: %w", err)
@@ -128,4 +136,268 @@ func UploadToMinIO(reader io.Reader, objectName string, size int64) (string, err
 
 	log.Printf("文件已上传: %s", objectName)
 	return presignedURL.String(), nil // 修改这里：返回 presignedURL.String()
-}
\ No newline at end of file
+}
+
+const (
+	multipartPartSize  = 8 * 1024 * 1024 // 8MiB，单个分片大小
+	multipartMaxRetry  = 5               // 单个分片的最大尝试次数（含首次上传）
+	multipartRetryBase = 500 * time.Millisecond
+)
+
+// UploadToMinIOResumable 以分片上传的方式把本地文件写入 MinIO，支持断点续传，用于大体积的成片视频。
+// localPath 必须是可 Seek 的本地文件（由调用方先下载到磁盘），这样才能在续传时跳过已提交的分片重新读取对应字节区间。
+//   - 每个分片上传失败时按指数退避+抖动重试，连同首次上传最多尝试 multipartMaxRetry 次
+//   - 分片成功后落一条 models.UploadCheckpoint；进程重启后通过它 + MinIO 的 ListObjectParts 对账，从断点续传而不是重新上传整份文件
+//   - ctx 被取消时（例如 CancelPollTask）中止 multipart upload 并清理检查点，返回 ctx.Err()
+//
+// 返回 (预签名 URL, 文件内容的 sha256 十六进制摘要, error)
+func UploadToMinIOResumable(ctx context.Context, taskID string, localPath string, objectName string) (string, string, error) {
+	cfg := config.AppConfig.MinIO
+	bucketName := cfg.Bucket
+
+	exists, err := MinioClient.BucketExists(ctx, bucketName)
+	if err != nil {
+		return "", "", fmt.Errorf("检查 Bucket 失败: %w", err)
+	}
+	if !exists {
+		if err := MinioClient.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{}); err != nil {
+			return "", "", fmt.Errorf("创建 Bucket 失败: %w", err)
+		}
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return "", "", fmt.Errorf("打开本地文件失败: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return "", "", fmt.Errorf("读取文件信息失败: %w", err)
+	}
+	size := stat.Size()
+
+	core := &minio.Core{Client: MinioClient}
+	contentType := contentTypeFromObjectName(objectName)
+
+	uploadID, startPart, err := resumeOrStartMultipartUpload(ctx, core, bucketName, objectName, taskID, contentType)
+	if err != nil {
+		return "", "", err
+	}
+
+	existingCheckpoints, err := models.ListUploadCheckpoints(models.GormDB, taskID, objectName)
+	if err != nil {
+		return "", "", fmt.Errorf("读取上传检查点失败: %w", err)
+	}
+	etagByPart := make(map[int]string, len(existingCheckpoints))
+	for _, cp := range existingCheckpoints {
+		etagByPart[cp.PartNumber] = cp.ETag
+	}
+
+	hasher := sha256.New()
+	var parts []minio.CompletePart
+	partNumber := 1
+	offset := int64(0)
+	for offset < size {
+		partSize := int64(multipartPartSize)
+		if remaining := size - offset; remaining < partSize {
+			partSize = remaining
+		}
+
+		// 无论该分片是否需要重新上传，都要把这段字节喂给哈希器，保证最终摘要覆盖全文件内容
+		buf := make([]byte, partSize)
+		if _, err := io.ReadFull(io.NewSectionReader(f, offset, partSize), buf); err != nil {
+			return "", "", fmt.Errorf("读取分片 %d 失败: %w", partNumber, err)
+		}
+		hasher.Write(buf)
+
+		if partNumber < startPart {
+			if etag, ok := etagByPart[partNumber]; ok {
+				parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: etag})
+			}
+			partNumber++
+			offset += partSize
+			continue
+		}
+
+		objPart, err := uploadPartWithRetry(ctx, core, bucketName, objectName, uploadID, partNumber, buf)
+		if err != nil {
+			if errors.Is(ctx.Err(), context.Canceled) {
+				_ = core.AbortMultipartUpload(context.Background(), bucketName, objectName, uploadID)
+				_ = models.DeleteUploadCheckpoints(models.GormDB, taskID, objectName)
+				return "", "", context.Canceled
+			}
+			return "", "", fmt.Errorf("上传分片 %d 失败: %w", partNumber, err)
+		}
+
+		if err := models.UpsertUploadCheckpoint(models.GormDB, &models.UploadCheckpoint{
+			TaskID:     taskID,
+			ObjectName: objectName,
+			PartNumber: partNumber,
+			UploadID:   uploadID,
+			ETag:       objPart.ETag,
+			Size:       partSize,
+		}); err != nil {
+			log.Printf("落盘上传检查点失败（不影响上传本身）: %v", err)
+		}
+
+		parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: objPart.ETag})
+		partNumber++
+		offset += partSize
+	}
+
+	if _, err := core.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, parts, minio.PutObjectOptions{}); err != nil {
+		return "", "", fmt.Errorf("完成分片上传失败: %w", err)
+	}
+	_ = models.DeleteUploadCheckpoints(models.GormDB, taskID, objectName)
+
+	presignedURL, err := MinioClient.PresignedGetObject(ctx, bucketName, objectName, time.Hour*72, make(url.Values))
+	if err != nil {
+		return "", "", fmt.Errorf("生成签名 URL 失败: %w", err)
+	}
+
+	log.Printf("文件已通过分片上传完成: %s", objectName)
+	return presignedURL.String(), hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// resumeOrStartMultipartUpload 若本地已有该 (taskID, objectName) 的检查点，尝试复用其 uploadID 续传；
+// 否则（或续传校验失败）发起一个新的 multipart upload。返回 uploadID 和应当从哪个分片号继续上传。
+func resumeOrStartMultipartUpload(ctx context.Context, core *minio.Core, bucket, objectName, taskID, contentType string) (string, int, error) {
+	checkpoints, err := models.ListUploadCheckpoints(models.GormDB, taskID, objectName)
+	if err != nil {
+		return "", 0, fmt.Errorf("读取上传检查点失败: %w", err)
+	}
+	if len(checkpoints) > 0 {
+		uploadID := checkpoints[0].UploadID
+		result, err := core.ListObjectParts(ctx, bucket, objectName, uploadID, 0, 10000)
+		if err == nil {
+			log.Printf("检测到未完成的分片上传，从断点续传: object=%s uploadID=%s 已完成分片=%d", objectName, uploadID, len(result.ObjectParts))
+			return uploadID, len(checkpoints) + 1, nil
+		}
+		log.Printf("续传校验失败（重新发起 multipart upload）: %v", err)
+		_ = models.DeleteUploadCheckpoints(models.GormDB, taskID, objectName)
+	}
+
+	uploadID, err := core.NewMultipartUpload(ctx, bucket, objectName, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", 0, fmt.Errorf("发起分片上传失败: %w", err)
+	}
+	return uploadID, 1, nil
+}
+
+// uploadPartWithRetry 上传单个分片，失败时按指数退避+抖动重试。每次尝试都从 buf 重新构造 reader，
+// 避免第一次尝试读到一半失败后，重试时数据流已被消费掉一部分。
+func uploadPartWithRetry(ctx context.Context, core *minio.Core, bucket, objectName, uploadID string, partNumber int, buf []byte) (minio.ObjectPart, error) {
+	var lastErr error
+	for attempt := 0; attempt < multipartMaxRetry; attempt++ {
+		if ctx.Err() != nil {
+			return minio.ObjectPart{}, ctx.Err()
+		}
+		if attempt > 0 {
+			backoff := time.Duration(math.Pow(2, float64(attempt))) * multipartRetryBase
+			jitter := time.Duration(rand.Int63n(int64(multipartRetryBase)))
+			select {
+			case <-time.After(backoff + jitter):
+			case <-ctx.Done():
+				return minio.ObjectPart{}, ctx.Err()
+			}
+			log.Printf("重试上传分片 %d（第 %d 次）: object=%s", partNumber, attempt+1, objectName)
+		}
+		objPart, err := core.PutObjectPart(ctx, bucket, objectName, uploadID, partNumber, bytes.NewReader(buf), int64(len(buf)), minio.PutObjectPartOptions{})
+		if err == nil {
+			return objPart, nil
+		}
+		lastErr = err
+	}
+	return minio.ObjectPart{}, lastErr
+}
+
+func contentTypeFromObjectName(objectName string) string {
+	switch filepath.Ext(objectName) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	case ".mp4":
+		return "video/mp4"
+	case ".mp3":
+		return "audio/mpeg"
+	case ".wav":
+		return "audio/wav"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// PresignObject 为已存在的 MinIO 对象生成一个新的预签名 URL，有效期由调用方通过 expiry 指定。
+// HLS 播放列表里的分片/子播放列表需要在每次被请求时重新签名，因为旧的签名会过期。
+func PresignObject(objectName string, expiry time.Duration) (string, error) {
+	ctx := context.Background()
+	cfg := config.AppConfig.MinIO
+	presignedURL, err := MinioClient.PresignedGetObject(ctx, cfg.Bucket, objectName, expiry, make(url.Values))
+	if err != nil {
+		return "", fmt.Errorf("生成签名 URL 失败: %w", err)
+	}
+	return presignedURL.String(), nil
+}
+
+// DeleteObject 删除一个 MinIO 对象，供审核回调在产物被判定 blocked 时清理资源使用
+func DeleteObject(objectName string) error {
+	ctx := context.Background()
+	cfg := config.AppConfig.MinIO
+	if err := MinioClient.RemoveObject(ctx, cfg.Bucket, objectName, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("删除 MinIO 对象失败: %w", err)
+	}
+	return nil
+}
+
+// GetObjectText 读取一个较小的文本对象（m3u8 播放列表）的全部内容
+func GetObjectText(objectName string) (string, error) {
+	ctx := context.Background()
+	cfg := config.AppConfig.MinIO
+	obj, err := MinioClient.GetObject(ctx, cfg.Bucket, objectName, minio.GetObjectOptions{})
+	if err != nil {
+		return "", fmt.Errorf("读取 MinIO 对象失败: %w", err)
+	}
+	defer obj.Close()
+	data, err := io.ReadAll(obj)
+	if err != nil {
+		return "", fmt.Errorf("读取 MinIO 对象内容失败: %w", err)
+	}
+	return string(data), nil
+}
+
+// UploadDirToMinIO 递归上传本地目录下所有文件，云端路径为 objectPrefix + 相对路径。
+// 用于一次性上传 HLS 打包产物（各码率的 index.m3u8 + .ts 分片 + master.m3u8）。
+// 返回值为已上传对象的云端路径列表（不含签名 URL，HLS 对象按需现签）。
+func UploadDirToMinIO(localDir, objectPrefix string) ([]string, error) {
+	var uploaded []string
+	err := filepath.Walk(localDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(localDir, path)
+		if err != nil {
+			return err
+		}
+		objectName := objectPrefix + "/" + filepath.ToSlash(rel)
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("打开文件 %s 失败: %w", path, err)
+		}
+		defer f.Close()
+		if _, err := UploadToMinIO(f, objectName, info.Size()); err != nil {
+			return fmt.Errorf("上传 %s 失败: %w", objectName, err)
+		}
+		uploaded = append(uploaded, objectName)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploaded, nil
+}
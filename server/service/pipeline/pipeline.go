@@ -0,0 +1,373 @@
+// Package pipeline 把一个项目的生成流程建模成一张 PipelineNode DAG：每个节点代表某个
+// shot（或整个项目，storyboard 没有 ShotID）在某个 stage 上的一次执行。节点在依赖全部
+// PipelineNodeStatusCompleted 后自动解锁——创建对应的 Task 并入队，不需要客户端轮询触发
+// 下一阶段。本包只依赖 models，不依赖 service，入队动作通过调用方传入的 EnqueueFunc 完成
+// （service 包反过来依赖本包，直接依赖会形成循环 import）。
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"StoryToVideo-server/models"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EnqueueFunc 由调用方注入（通常是 service.EnqueueTask），避免本包直接依赖 service
+type EnqueueFunc func(taskID string) error
+
+// StageSpec 描述 DAG 中一个 stage：DependsOn 引用的是同一个 Spec 里其它 stage 的名字
+// （取值同 models.TaskType*），而不是具体的节点 ID——具体节点 ID 由 Materialize 解析。
+type StageSpec struct {
+	Stage       string                 `json:"stage"`
+	DependsOn   []string               `json:"depends_on,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Concurrency int                    `json:"concurrency,omitempty"` // 预留给后续的按类型并发限制
+	MaxRetry    int                    `json:"max_retry,omitempty"`   // 预留给后续的重试策略
+}
+
+// Spec 是 POST /v1/api/projects/:project_id/pipeline 接受的声明式 pipeline 定义
+type Spec struct {
+	Stages []StageSpec `json:"stages"`
+}
+
+// isProjectScoped 报告某个 stage 是否是项目级（只有一个节点），而不是按 shot 各一个节点
+func isProjectScoped(stage string) bool {
+	return stage == models.TaskTypeStoryboard
+}
+
+// Materialize 把声明式 spec 物化为 PipelineNode 并持久化：项目已有的 shots 数量决定按 shot
+// 展开的 stage 会创建多少个节点；若 shots 还不存在（典型情况是 storyboard 还没跑完），按 shot
+// 展开的 stage 本次先跳过，等 storyboard 完成后由 OnTaskCompleted 的 fan-out 逻辑补上。
+// 没有依赖的节点（如 storyboard）会立即创建 Task 并入队。
+func Materialize(db *gorm.DB, projectID string, spec Spec, enqueue EnqueueFunc) ([]models.PipelineNode, error) {
+	shots, err := models.GetShotsByProjectID(projectID)
+	if err != nil {
+		return nil, fmt.Errorf("加载 shots 失败: %w", err)
+	}
+
+	// stage -> (shotID -> 节点下标)，用于把 depends_on 里的 stage 名解析成具体节点 ID；
+	// 项目级节点记在 shotID == "" 这个槽位下。两轮扫描：先为每个 (stage, shot) 分配好节点 ID，
+	// 再解析依赖，这样 depends_on 引用排在 spec.Stages 后面的 stage 也能正确解析。
+	nodesByStage := make(map[string]map[string]int)
+	var nodes []models.PipelineNode
+
+	for _, ss := range spec.Stages {
+		byShot := make(map[string]int)
+		nodesByStage[ss.Stage] = byShot
+
+		shotScopes := []string{""}
+		if !isProjectScoped(ss.Stage) {
+			if len(shots) == 0 {
+				continue
+			}
+			shotScopes = shotScopes[:0]
+			for _, s := range shots {
+				shotScopes = append(shotScopes, s.ID)
+			}
+		}
+
+		for _, shotID := range shotScopes {
+			nodes = append(nodes, models.PipelineNode{
+				ID:         uuid.NewString(),
+				ProjectID:  projectID,
+				ShotID:     shotID,
+				Stage:      ss.Stage,
+				Status:     models.PipelineNodeStatusPending,
+				Parameters: ss.Parameters,
+			})
+			byShot[shotID] = len(nodes) - 1
+		}
+	}
+
+	var rootIdx []int // 无依赖、需要立即入队的节点下标
+	for _, ss := range spec.Stages {
+		byShot, ok := nodesByStage[ss.Stage]
+		if !ok {
+			continue
+		}
+		for shotID, idx := range byShot {
+			var depIDs []string
+			for _, depStage := range ss.DependsOn {
+				if depsByShot, ok := nodesByStage[depStage]; ok {
+					if depIdx, ok := depsByShot[shotID]; ok {
+						depIDs = append(depIDs, nodes[depIdx].ID)
+					} else if depIdx, ok := depsByShot[""]; ok {
+						depIDs = append(depIDs, nodes[depIdx].ID) // 依赖项目级 stage
+					}
+				}
+			}
+			nodes[idx].DependsOn = depIDs
+			if len(depIDs) > 0 {
+				nodes[idx].Status = models.PipelineNodeStatusBlocked
+			} else {
+				rootIdx = append(rootIdx, idx)
+			}
+		}
+	}
+
+	if err := models.CreatePipelineNodes(db, nodes); err != nil {
+		return nil, fmt.Errorf("写入 pipeline_node 失败: %w", err)
+	}
+
+	for _, idx := range rootIdx {
+		if err := enqueueNode(db, &nodes[idx], enqueue); err != nil {
+			log.Printf("pipeline: 节点 %s 入队失败: %v", nodes[idx].ID, err)
+		}
+	}
+
+	return nodes, nil
+}
+
+// OnTaskCompleted 在 HandleGenerateTask 把某个 Task 标记为成功后调用：推进该 Task 绑定的
+// PipelineNode 到 Completed，并解锁依赖它的下游节点。若该 Task 不是由 pipeline 创建的
+// （例如旧的 CreateProject 手动编排流程），GetPipelineNodeByTaskID 查不到节点，直接忽略。
+func OnTaskCompleted(db *gorm.DB, task *models.Task, enqueue EnqueueFunc) error {
+	node, err := models.GetPipelineNodeByTaskID(db, task.ID)
+	if err != nil {
+		return nil
+	}
+
+	if err := models.UpdatePipelineNodeStatus(db, node.ID, models.PipelineNodeStatusCompleted, ""); err != nil {
+		return fmt.Errorf("更新节点 %s 状态失败: %w", node.ID, err)
+	}
+
+	if node.Stage == models.TaskTypeStoryboard {
+		if err := fanOutAfterStoryboard(db, node, enqueue); err != nil {
+			return fmt.Errorf("storyboard 完成后展开 shot 节点失败: %w", err)
+		}
+	}
+
+	return advanceDependents(db, node.ProjectID, node.ID, enqueue)
+}
+
+// fanOutAfterStoryboard 是 storyboard 节点专属的展开规则：这时 handleStoryboardResult 已经
+// 把 shots 写入了 DB，为每个 shot 各建一个 image 节点 + 一个 tts 节点（都立即入队，因为它们
+// 唯一的依赖——storyboard——已经完成），以及一个 video 节点（依赖同 shot 的 image 节点，
+// 要等图生成完才能解锁）。
+func fanOutAfterStoryboard(db *gorm.DB, storyboardNode *models.PipelineNode, enqueue EnqueueFunc) error {
+	shots, err := models.GetShotsByProjectID(storyboardNode.ProjectID)
+	if err != nil {
+		return fmt.Errorf("加载 shots 失败: %w", err)
+	}
+	if len(shots) == 0 {
+		return nil
+	}
+
+	var nodes []models.PipelineNode
+	var enqueueIdx []int
+	for _, shot := range shots {
+		imageNode := models.PipelineNode{
+			ID:        uuid.NewString(),
+			ProjectID: storyboardNode.ProjectID,
+			ShotID:    shot.ID,
+			Stage:     models.TaskTypeShotImage,
+			Status:    models.PipelineNodeStatusPending,
+			Parameters: models.PipelineParameters{
+				"prompt":       shot.Prompt,
+				"transition":   shot.Transition,
+				"image_width":  "1024",
+				"image_height": "1024",
+			},
+		}
+		ttsNode := models.PipelineNode{
+			ID:        uuid.NewString(),
+			ProjectID: storyboardNode.ProjectID,
+			ShotID:    shot.ID,
+			Stage:     models.TaskTypeProjectAudio,
+			Status:    models.PipelineNodeStatusPending,
+		}
+
+		nodes = append(nodes, imageNode)
+		enqueueIdx = append(enqueueIdx, len(nodes)-1)
+		nodes = append(nodes, ttsNode)
+		enqueueIdx = append(enqueueIdx, len(nodes)-1)
+
+		videoNode := models.PipelineNode{
+			ID:        uuid.NewString(),
+			ProjectID: storyboardNode.ProjectID,
+			ShotID:    shot.ID,
+			Stage:     models.TaskTypeVideoGen,
+			Status:    models.PipelineNodeStatusBlocked,
+			DependsOn: models.StringSlice{imageNode.ID},
+		}
+		nodes = append(nodes, videoNode)
+	}
+
+	if err := models.CreatePipelineNodes(db, nodes); err != nil {
+		return fmt.Errorf("写入 pipeline_node 失败: %w", err)
+	}
+	for _, idx := range enqueueIdx {
+		if err := enqueueNode(db, &nodes[idx], enqueue); err != nil {
+			log.Printf("pipeline: fan-out 节点 %s 入队失败: %v", nodes[idx].ID, err)
+		}
+	}
+	return nil
+}
+
+// advanceDependents 找到依赖 completedNodeID 的节点，依赖已全部满足的就地解锁入队
+func advanceDependents(db *gorm.DB, projectID, completedNodeID string, enqueue EnqueueFunc) error {
+	dependents, err := models.GetPipelineNodesDependingOn(db, projectID, completedNodeID)
+	if err != nil {
+		return fmt.Errorf("查询下游节点失败: %w", err)
+	}
+
+	for i := range dependents {
+		n := dependents[i]
+		if n.Status != models.PipelineNodeStatusBlocked && n.Status != models.PipelineNodeStatusPending {
+			continue
+		}
+		ready, err := allDepsCompleted(db, n.DependsOn)
+		if err != nil {
+			log.Printf("pipeline: 检查节点 %s 依赖状态失败: %v", n.ID, err)
+			continue
+		}
+		if !ready {
+			continue
+		}
+		if err := enqueueNode(db, &n, enqueue); err != nil {
+			log.Printf("pipeline: 节点 %s 入队失败: %v", n.ID, err)
+		}
+	}
+	return nil
+}
+
+func allDepsCompleted(db *gorm.DB, depIDs []string) (bool, error) {
+	for _, id := range depIDs {
+		var dep models.PipelineNode
+		if err := db.Where("id = ?", id).First(&dep).Error; err != nil {
+			return false, err
+		}
+		if dep.Status != models.PipelineNodeStatusCompleted {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// enqueueNode 把一个依赖已满足的节点转成真正的 Task：创建 Task 行、调用 enqueue 入队、
+// 把节点状态置为 Queued 并绑定 TaskID
+func enqueueNode(db *gorm.DB, node *models.PipelineNode, enqueue EnqueueFunc) error {
+	params, err := buildTaskParameters(node.Stage, node.Parameters, node.ShotID)
+	if err != nil {
+		return fmt.Errorf("构建 task 参数失败: %w", err)
+	}
+
+	task := models.Task{
+		ID:         uuid.NewString(),
+		ProjectId:  node.ProjectID,
+		ShotId:     node.ShotID,
+		Type:       node.Stage,
+		Status:     models.TaskStatusPending,
+		Message:    fmt.Sprintf("pipeline 节点 %s 依赖已满足，等待执行", node.ID),
+		Parameters: params,
+	}
+	if err := models.CreateTask(&task); err != nil {
+		return fmt.Errorf("创建 task 失败: %w", err)
+	}
+	// 先把节点标记为 Queued 并绑定 task_id，再真正入队：避免 worker 抢跑——在极低延迟的
+	// 队列下，Task 可能在 enqueue() 调用返回前就被 HandleGenerateTask 拿到并标记为 running，
+	// 那时节点必须已经能通过 task_id 反查到，否则 running 状态会更新不到节点上
+	if err := models.UpdatePipelineNodeStatus(db, node.ID, models.PipelineNodeStatusQueued, task.ID); err != nil {
+		return fmt.Errorf("更新节点状态失败: %w", err)
+	}
+	node.TaskID = task.ID
+	node.Status = models.PipelineNodeStatusQueued
+
+	if err := enqueue(task.ID); err != nil {
+		return fmt.Errorf("task 入队失败: %w", err)
+	}
+	return nil
+}
+
+// buildTaskParameters 把节点上存的原始模板变量（map[string]interface{}）按 stage 转换成
+// Task 实际需要的 TaskParameters 子结构
+func buildTaskParameters(stage string, raw models.PipelineParameters, shotID string) (models.TaskParameters, error) {
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return models.TaskParameters{}, err
+	}
+
+	params := models.TaskParameters{}
+	switch stage {
+	case models.TaskTypeStoryboard:
+		p := &models.ShotDefaultsParams{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return params, err
+		}
+		params.ShotDefaults = p
+	case models.TaskTypeShotImage, "regenerate_shot":
+		p := &models.ShotParams{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return params, err
+		}
+		p.ShotId = shotID
+		params.Shot = p
+	case models.TaskTypeProjectAudio:
+		p := &models.TTSParams{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return params, err
+		}
+		params.TTS = p
+	case models.TaskTypeVideoGen:
+		p := &models.VideoParams{}
+		if err := json.Unmarshal(b, p); err != nil {
+			return params, err
+		}
+		params.Video = p
+	default:
+		return params, fmt.Errorf("未知的 pipeline stage: %s", stage)
+	}
+	return params, nil
+}
+
+// TaskEventFunc 由调用方注入（通常是 service.PublishTaskEvent 的瘦包装），用来把级联取消
+// 广播给 task:events 订阅者；与 EnqueueFunc 一样，是为了避免本包直接依赖 service 形成循环
+// import。调用方不关心广播时传 nil。
+type TaskEventFunc func(taskID, projectID, status string)
+
+// CancelDescendants 级联取消：把 taskID 对应节点的所有尚未开始（非 running/completed）的
+// 后代节点标记为 cancelled，已经创建了 Task 行的也一并把 Task 标记为 cancelled，避免父任务
+// 被 CancelPollTask 取消后，还在等待解锁的下游节点继续被创建/入队执行。
+func CancelDescendants(db *gorm.DB, taskID string, onTaskEvent TaskEventFunc) error {
+	node, err := models.GetPipelineNodeByTaskID(db, taskID)
+	if err != nil {
+		return nil
+	}
+	return cancelFrom(db, node.ProjectID, node.ID, make(map[string]bool), onTaskEvent)
+}
+
+func cancelFrom(db *gorm.DB, projectID, nodeID string, visited map[string]bool, onTaskEvent TaskEventFunc) error {
+	if visited[nodeID] {
+		return nil
+	}
+	visited[nodeID] = true
+
+	dependents, err := models.GetPipelineNodesDependingOn(db, projectID, nodeID)
+	if err != nil {
+		return fmt.Errorf("查询下游节点失败: %w", err)
+	}
+
+	for _, n := range dependents {
+		if n.Status == models.PipelineNodeStatusCompleted || n.Status == models.PipelineNodeStatusRunning {
+			continue // 已经开始/完成的节点不回滚，只拦截尚未开始的
+		}
+		if err := models.UpdatePipelineNodeStatus(db, n.ID, models.PipelineNodeStatusCancelled, ""); err != nil {
+			log.Printf("pipeline: 取消节点 %s 失败: %v", n.ID, err)
+		}
+		if n.TaskID != "" {
+			if err := models.UpdateTaskStatus(n.TaskID, models.TaskStatusCancelled, nil, nil, nil, nil, nil, nil); err != nil {
+				log.Printf("pipeline: 取消 task %s 失败: %v", n.TaskID, err)
+			} else if onTaskEvent != nil {
+				onTaskEvent(n.TaskID, projectID, models.TaskStatusCancelled)
+			}
+		}
+		if err := cancelFrom(db, projectID, n.ID, visited, onTaskEvent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -9,11 +9,17 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"StoryToVideo-server/config"
 	"StoryToVideo-server/models"
+	"StoryToVideo-server/service/moderation"
+	"StoryToVideo-server/service/orchestrator"
+	"StoryToVideo-server/service/pipeline"
+	"StoryToVideo-server/service/streaming"
 
 	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
@@ -66,22 +72,69 @@ func UnregisterPollCancel(taskID string) {
 	delete(pollCancelRegistry.m, taskID)
 }
 
-// CancelPollTask 外部调用以取消正在轮询的任务，返回是否实际找到并取消
+// CancelPollTask 外部调用以取消正在轮询的任务，返回是否实际找到并取消；若该 task 绑定了
+// pipeline 节点，级联把所有尚未开始的下游节点（及其 Task）一并标记为 cancelled，避免它们
+// 在父任务被取消后继续解锁执行
 func CancelPollTask(taskID string) bool {
 	pollCancelRegistry.Lock()
-	defer pollCancelRegistry.Unlock()
-	if cancel, ok := pollCancelRegistry.m[taskID]; ok {
+	cancel, ok := pollCancelRegistry.m[taskID]
+	if ok {
 		cancel()
 		delete(pollCancelRegistry.m, taskID)
-		return true
 	}
-	return false
+	pollCancelRegistry.Unlock()
+
+	if ok {
+		if err := pipeline.CancelDescendants(models.GormDB, taskID, PublishStatusEvent); err != nil {
+			log.Printf("级联取消 pipeline 下游节点失败: %v", err)
+		}
+	}
+	return ok
+}
+
+// cancelConcurrency 限制 CancelTasks 同时向 worker 发起删除请求的数量，避免一次批量取消
+// 几十个任务时把 worker 的 HTTP 接口打爆。
+const cancelConcurrency = 8
+
+// CancelResult 是 CancelTasks 对单个任务取消尝试的结果，TaskID 对应传入的 models.Task.ID；
+// Err 仅反映通知 worker 删除 job 是否失败，CancelPollTask 找不到对应轮询不算错误。
+type CancelResult struct {
+	TaskID string
+	Err    error
+}
+
+// CancelTasks 用固定大小的 worker pool 并发取消一批任务：有 Result.ResourceId 的尝试通知
+// worker 删除对应 job，再取消本地轮询（如果存在）。取代 UpdateProject/DeleteProject 里原来
+// 逐条任务串行调用 CancelWorkerJob/CancelPollTask 的写法。返回结果与 tasks 一一对应。
+func CancelTasks(tasks []models.Task) []CancelResult {
+	results := make([]CancelResult, len(tasks))
+	sem := make(chan struct{}, cancelConcurrency)
+	var wg sync.WaitGroup
+	for i, t := range tasks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, t models.Task) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			var err error
+			if t.Result.ResourceId != "" {
+				if e := CancelWorkerJob(t.Result.ResourceId); e != nil {
+					err = fmt.Errorf("通知 worker 删除 job %s 失败: %w", t.Result.ResourceId, e)
+				}
+			}
+			CancelPollTask(t.ID)
+			results[i] = CancelResult{TaskID: t.ID, Err: err}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
 }
 
 // Processor 处理队列任务
 type Processor struct {
 	DB             *gorm.DB
 	WorkerEndpoint string
+	Moderator      moderation.Moderator
 }
 
 func NewProcessor(db *gorm.DB) *Processor {
@@ -89,6 +142,40 @@ func NewProcessor(db *gorm.DB) *Processor {
 	return &Processor{
 		DB:             db,
 		WorkerEndpoint: config.AppConfig.Worker.Addr,
+		Moderator:      newModerator(),
+	}
+}
+
+// newModerator 根据配置选择审核供应商，默认走直通 mock（本地开发/未配置时）
+func newModerator() moderation.Moderator {
+	if config.AppConfig == nil {
+		return moderation.NewModerationMock()
+	}
+	cfg := config.AppConfig.Moderation
+	switch cfg.Provider {
+	case "aliyun_green":
+		return moderation.NewAliyunGreen(cfg.Endpoint, cfg.AccessKey, cfg.SecretKey)
+	case "local_nsfw":
+		return moderation.NewLocalNSFW(cfg.Endpoint, 0)
+	default:
+		return moderation.NewModerationMock()
+	}
+}
+
+// orchestratorFailurePolicy 读取父任务 failed/cancelled 时子任务的级联策略，未配置时默认
+// cascade_cancel（和 newModerator 的默认值兜底是同一套写法）
+func orchestratorFailurePolicy() string {
+	if config.AppConfig == nil || config.AppConfig.Orchestrator.FailurePolicy == "" {
+		return orchestrator.FailurePolicyCascadeCancel
+	}
+	return config.AppConfig.Orchestrator.FailurePolicy
+}
+
+// ResumeOrchestratorTasks 在进程启动时调用一次：重新扫描依赖其实已经满足的 blocked 任务并
+// 入队，让上次进程崩溃前卡在 blocked 状态的 pipeline 不需要人工介入就能继续跑完。
+func ResumeOrchestratorTasks(db *gorm.DB) {
+	if err := orchestrator.ResumeBlockedTasks(db, EnqueueTask, PublishStatusEvent); err != nil {
+		log.Printf("orchestrator: 启动时恢复 blocked 任务失败: %v", err)
 	}
 }
 
@@ -101,8 +188,13 @@ func (p *Processor) StartProcessor(concurrency int) {
 		},
 		asynq.Config{
 			Concurrency: concurrency,
+			// 按权重做加权轮询：critical 任务（storyboard 等前置依赖）被抽到的概率是
+			// low 任务（video 等最耗资源的收尾阶段）的 6 倍，但 low 仍保证会被处理，
+			// 不会被饿死，用来配合 EnqueueTask 按 task.Priority 分配的三档队列
 			Queues: map[string]int{
-				"default": 1,
+				QueueCritical: 6,
+				QueueDefault:  3,
+				QueueLow:      1,
 			},
 		},
 	)
@@ -136,32 +228,78 @@ func (p *Processor) HandleGenerateTask(ctx context.Context, t *asynq.Task) error
 	if err := task.UpdateStatus(p.DB, models.TaskStatusProcessing, nil, ""); err != nil {
 		log.Printf("UpdateStatus processing failed: %v", err)
 	}
+	PublishTaskUpdate(task)
+	if node, err := models.GetPipelineNodeByTaskID(p.DB, task.ID); err == nil {
+		if err := models.UpdatePipelineNodeStatus(p.DB, node.ID, models.PipelineNodeStatusRunning, ""); err != nil {
+			log.Printf("更新 pipeline 节点为 running 失败: %v", err)
+		}
+	}
 
 	if task.Type == "create_project" {
 		// 直接标记为完成
 		task.UpdateStatus(p.DB, models.TaskStatusSuccess, nil, "Project initialized")
+		PublishTaskUpdate(task)
+		if err := orchestrator.OnTaskFinished(p.DB, task.ID, EnqueueTask, PublishStatusEvent); err != nil {
+			log.Printf("orchestrator: 推进依赖任务失败: %v", err)
+		}
+		return nil
+	}
+	if task.Type == models.TaskTypeModeration {
+		// 内容审核任务不走通用的 worker 请求/轮询流程，审核供应商不是生成 worker
+		if err := p.handleModerationTask(task); err != nil {
+			log.Printf("[Error] 内容审核处理失败: %v", err)
+			task.UpdateStatus(p.DB, models.TaskStatusFailed, nil, err.Error())
+			PublishTaskUpdate(task)
+			if err := orchestrator.OnTaskFailed(p.DB, task.ID, orchestratorFailurePolicy(), PublishStatusEvent); err != nil {
+				log.Printf("orchestrator: 级联取消依赖任务失败: %v", err)
+			}
+		}
 		return nil
 	}
 	jobID, err := p.dispatchWorkerRequest(task)
 	if err != nil {
 		log.Printf("Worker 请求失败: %v", err)
 		task.UpdateStatus(p.DB, models.TaskStatusFailed, nil, fmt.Sprintf("Worker Request Failed: %v", err))
-		return err // 返回 err 触发重试
+		PublishTaskUpdate(task)
+		if err := orchestrator.OnTaskFailed(p.DB, task.ID, orchestratorFailurePolicy(), PublishStatusEvent); err != nil {
+			log.Printf("orchestrator: 级联取消依赖任务失败: %v", err)
+		}
+		p.reportShardOutcomeIfTerminal(task)
+		return nil // 业务失败交给 attempts/max_attempts 重试，不再让 asynq 并行重试同一个任务
 	}
-	if err := models.UpdateTaskStatus(task.ID, models.TaskStatusProcessing, nil, nil, &models.TaskResult{ResourceId: jobID}, nil, nil, nil); err != nil {
+	jobResult := &models.TaskResult{ResourceId: jobID}
+	if err := models.UpdateTaskStatus(task.ID, models.TaskStatusProcessing, nil, nil, jobResult, nil, nil, nil); err != nil {
 		log.Printf("写入 job_id 到 task.result 失败: %v", err)
 	}
-	log.Printf("任务已提交，Job ID: %s，开始轮询结果...", jobID)
-	// 为轮询创建可取消的子上下文并注册 cancel（外部 API 可通过 CancelPollTask 取消）
+	PublishTaskEvent(TaskEvent{
+		TaskID:    task.ID,
+		ProjectID: task.ProjectId,
+		Status:    models.TaskStatusProcessing,
+		Progress:  task.Progress,
+		Message:   task.Message,
+		Result:    jobResult,
+	})
+	log.Printf("任务已提交，Job ID: %s，等待结果...", jobID)
+	// 为轮询/等待创建可取消的子上下文并注册 cancel（外部 API 可通过 CancelPollTask 取消）
 	pollCtx, cancel := context.WithCancel(ctx)
 	RegisterPollCancel(task.ID, cancel)
 	// 确保在本函数结束时注销
 	defer UnregisterPollCancel(task.ID)
 
-	taskResult, err := p.pollJobResult(pollCtx, jobID)
+	var taskResult *models.TaskResult
+	if config.AppConfig != nil && config.AppConfig.Worker.UseCallback {
+		taskResult, err = p.waitJobResult(pollCtx, task.ID, jobID)
+	} else {
+		taskResult, err = p.pollJobResult(pollCtx, jobID)
+	}
 	if err != nil {
-		log.Printf("轮询任务失败: %v", err)
+		log.Printf("等待任务结果失败: %v", err)
 		task.UpdateStatus(p.DB, models.TaskStatusFailed, nil, fmt.Sprintf("Job Failed: %v", err))
+		PublishTaskUpdate(task)
+		if err := orchestrator.OnTaskFailed(p.DB, task.ID, orchestratorFailurePolicy(), PublishStatusEvent); err != nil {
+			log.Printf("orchestrator: 级联取消依赖任务失败: %v", err)
+		}
+		p.reportShardOutcomeIfTerminal(task)
 		return nil // 业务失败，不再重试
 	}
 
@@ -177,21 +315,21 @@ func (p *Processor) HandleGenerateTask(ctx context.Context, t *asynq.Task) error
 		if shotId == "" && task.Parameters.Shot != nil {
 			shotId = task.Parameters.Shot.ShotId
 		}
-		processingErr = p.handleImageResult(shotId, taskResult)
+		processingErr = p.handleImageResult(pollCtx, task.ID, shotId, taskResult)
 
 	case models.TaskTypeProjectAudio: // 文本 -> 语音
 		shotId := task.ShotId
 		if shotId == "" && task.Parameters.Shot != nil {
 			shotId = task.Parameters.Shot.ShotId
 		}
-		processingErr = p.handleTTSResult(shotId, taskResult)
+		processingErr = p.handleTTSResult(pollCtx, task.ID, shotId, taskResult)
 
 	case models.TaskTypeVideoGen: // 图 -> 视频
 		shotId := task.ShotId
 		if shotId == "" && task.Parameters.Shot != nil {
 			shotId = task.Parameters.Shot.ShotId
 		}
-		processingErr = p.handleVideoResult(shotId, taskResult)
+		processingErr = p.handleVideoResult(pollCtx, task.ID, shotId, taskResult)
 
 	default:
 		processingErr = fmt.Errorf("unknown task type: %s", task.Type)
@@ -200,15 +338,63 @@ func (p *Processor) HandleGenerateTask(ctx context.Context, t *asynq.Task) error
 	if processingErr != nil {
 		log.Printf("[Error] 数据处理失败: %v", processingErr)
 		task.UpdateStatus(p.DB, models.TaskStatusFailed, taskResult, processingErr.Error())
+		PublishTaskUpdate(task)
+		if err := orchestrator.OnTaskFailed(p.DB, task.ID, orchestratorFailurePolicy(), PublishStatusEvent); err != nil {
+			log.Printf("orchestrator: 级联取消依赖任务失败: %v", err)
+		}
+		p.reportShardOutcomeIfTerminal(task)
 		return nil
 	}
 
 	// 5. 成功完成
 	task.UpdateStatus(p.DB, models.TaskStatusSuccess, taskResult, "")
+	PublishTaskUpdate(task)
 	log.Printf("Task %s completed successfully", task.ID)
+
+	// 若该 task 是 pipeline DAG 的一个节点，推进 DAG：解锁依赖它的下游节点
+	// （storyboard 完成时还会展开出每个 shot 的 image/tts/video 节点）
+	if err := pipeline.OnTaskCompleted(p.DB, task, EnqueueTask); err != nil {
+		log.Printf("pipeline: 推进 DAG 失败: %v", err)
+	}
+	// Task.Parameters.DependsOn 链路（GenerateProjectTTS/GenerateShotVideo/UpdateShot 等直接
+	// 走 orchestrator.Submit 创建的任务）同样需要推进：和上面的 pipeline DAG 是两套互不重叠
+	// 的依赖表达方式，分别解锁各自的下游
+	if err := orchestrator.OnTaskFinished(p.DB, task.ID, EnqueueTask, PublishStatusEvent); err != nil {
+		log.Printf("orchestrator: 推进依赖任务失败: %v", err)
+	}
+	// 若该 task 是某次 SubmitSharded* 拆出的一个分片（Parameters.BatchID 非空），把它计入批次
+	// 进度；OnShardTaskFinished 对非分片任务直接 no-op
+	if err := OnShardTaskFinished(p.DB, task); err != nil {
+		log.Printf("batch: 上报分片完成失败: %v", err)
+	}
+	// 若该 task 是受审类型（generate_shot/generate_audio/generate_video）且产出了
+	// ResourceUrl，创建一个依赖它的 TaskTypeModeration 跟进任务；非受审类型直接 no-op
+	if err := SubmitModerationFollowUp(p.DB, task); err != nil {
+		log.Printf("moderation: 创建审核跟进任务失败: %v", err)
+	}
 	return nil
 }
 
+// reportShardOutcomeIfTerminal 在一个分片任务失败后调用：task.UpdateStatus 可能把它改判为
+// retry_scheduled（还有重试次数）而不是真正的终态 failed，这里重新加载一次任务确认落地状态，
+// 避免还会被 RetrySweeper 捡回去的分片被提前计入批次的 FailedShards
+func (p *Processor) reportShardOutcomeIfTerminal(task *models.Task) {
+	if task.Parameters.BatchID == "" {
+		return
+	}
+	reloaded, err := models.GetTaskByIDGorm(p.DB, task.ID)
+	if err != nil {
+		log.Printf("batch: 重新加载分片任务 %s 失败: %v", task.ID, err)
+		return
+	}
+	if reloaded.Status != models.TaskStatusFailed {
+		return
+	}
+	if err := OnShardTaskFailed(p.DB, reloaded); err != nil {
+		log.Printf("batch: 上报分片失败失败: %v", err)
+	}
+}
+
 // ============================================================================
 // 通信层：请求分发与轮询
 // ============================================================================
@@ -351,6 +537,88 @@ func (p *Processor) dispatchWorkerRequest(task *models.Task) (string, error) {
 	return "", fmt.Errorf("response missing 'id'")
 }
 
+// waitJobResult 替代轮询：阻塞等待 Worker 通过 POST /v1/api/worker/callback 回调上报的
+// 终态（由 service.JobHub 转发），仍然响应 ctx.Done()（CancelPollTask 可随时取消）。
+// 额外用一个 30s 的对账 ticker 兜底——只有当该 job 超过 30s 没有收到任何回调（可能是
+// 回调丢失/Worker 重启）时，才主动发起一次 GET /v1/jobs/{id} 校验状态，避免任务卡死。
+func (p *Processor) waitJobResult(ctx context.Context, taskID, jobID string) (*models.TaskResult, error) {
+	resultCh, unregister := DefaultJobHub.Register(jobID, taskID)
+	defer unregister()
+
+	const reconcileInterval = 30 * time.Second
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	timeoutDuration := 30 * time.Minute
+	timeout := time.After(timeoutDuration)
+
+	for {
+		select {
+		case <-timeout:
+			return nil, fmt.Errorf("job wait timeout")
+		case <-ctx.Done():
+			return nil, fmt.Errorf("job wait canceled: %v", ctx.Err())
+		case result := <-resultCh:
+			if err := DefaultJobHub.LastError(jobID); err != nil {
+				return nil, err
+			}
+			return result, nil
+		case <-ticker.C:
+			if last, ok := DefaultJobHub.LastUpdate(jobID); ok && time.Since(last) < reconcileInterval {
+				continue // 期间已经收到过回调，跳过这次对账
+			}
+			log.Printf("Job %s 超过 %s 未收到回调，发起对账轮询", jobID, reconcileInterval)
+			result, done, err := p.reconcileJob(jobID)
+			if err != nil {
+				log.Printf("对账轮询失败: %v", err)
+				if done {
+					return nil, err
+				}
+				continue
+			}
+			if done {
+				return result, nil
+			}
+		}
+	}
+}
+
+// reconcileJob 对失联的 job 发起一次性的 GET /v1/jobs/{id}，仅用于 waitJobResult 的
+// 对账兜底路径；done=true 时 result/err 为终态结果，done=false 时应继续等待下一次回调
+func (p *Processor) reconcileJob(jobID string) (result *models.TaskResult, done bool, err error) {
+	jobURL := fmt.Sprintf("%s/v1/jobs/%s", p.WorkerEndpoint, jobID)
+
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	resp, err := httpClient.Get(jobURL)
+	if err != nil {
+		return nil, false, fmt.Errorf("对账请求失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var raw struct {
+		Status   string             `json:"status"`
+		Progress int               `json:"progress"`
+		Message  string            `json:"message"`
+		Result   models.TaskResult `json:"result"`
+		Error    string            `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, false, fmt.Errorf("对账响应解析失败: %w", err)
+	}
+
+	status := NormalizeWorkerStatus(raw.Status)
+	switch status {
+	case models.TaskStatusSuccess:
+		return &raw.Result, true, nil
+	case models.TaskStatusFailed:
+		return nil, true, fmt.Errorf("worker reported failure: %s", raw.Error)
+	default:
+		// 仍在进行中：刷新 lastUpdate 避免下一轮对账立即重触发，顺带让 SSE 订阅者看到一次进度
+		DefaultJobHub.Publish(JobProgress{JobID: jobID, Status: status, Progress: raw.Progress, Message: raw.Message})
+		return nil, false, nil
+	}
+}
+
 // pollJobResult 轮询 GET /v1/jobs/{job_id} 直到完成，返回 TaskResult
 func (p *Processor) pollJobResult(ctx context.Context, jobID string) (*models.TaskResult, error) {
 	jobURL := fmt.Sprintf("%s/v1/jobs/%s", p.WorkerEndpoint, jobID)
@@ -531,6 +799,18 @@ func (p *Processor) handleStoryboardResult(projectID string, result *models.Task
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
 		}
+
+		// 分镜描述/提示词先过一遍文本审核，未通过的直接落库为 rejected，不进入后续的生图/生视频流程
+		if p.Moderator != nil {
+			if modResult, err := p.Moderator.ModerateText(context.Background(), shot.Description+"\n"+shot.Prompt); err != nil {
+				log.Printf("分镜文本审核失败（按通过处理）: %v", err)
+			} else if !modResult.Passed {
+				newShot.Status = models.ShotStatusRejected
+				newShot.Remarks = toModerationRemarks(modResult.Remarks)
+				newShot.ModerationScores = toModerationScores(modResult.Scores)
+			}
+		}
+
 		shotsToCreate = append(shotsToCreate, newShot)
 	}
 
@@ -545,9 +825,13 @@ func (p *Processor) handleStoryboardResult(projectID string, result *models.Task
 }
 
 // 处理图像生成结果 -> 更新 ImagePath
-func (p *Processor) handleImageResult(shotID string, result *models.TaskResult) error {
+// 产物本身的审核不在这里做：SubmitModerationFollowUp 会在本任务落成 TaskStatusSuccess 后
+// 创建一个依赖它的 TaskTypeModeration 任务，统一走 p.Moderator.SubmitAsset 审核，命中时把
+// 这个任务本身 flip 成 TaskStatusBlockedByModeration（见 moderation_task.go）——不再像过去
+// 那样在这里再调用一次 ModerateImage 重复审核同一张图。
+func (p *Processor) handleImageResult(ctx context.Context, taskID, shotID string, result *models.TaskResult) error {
 	objectName := fmt.Sprintf("shots/%s/image.png", shotID)
-	finalURL, err := processResourceToMinIO(result, objectName)
+	finalURL, sha256Hex, err := processResourceToMinIO(ctx, taskID, result, objectName)
 	if err != nil {
 		return fmt.Errorf("处理图片资源失败: %v", err)
 	}
@@ -556,61 +840,179 @@ func (p *Processor) handleImageResult(shotID string, result *models.TaskResult)
 	if err != nil {
 		return err
 	}
+
 	log.Printf("图片id %s上传成功: %s", shotID, finalURL)
-	return shot.UpdateImage(p.DB, finalURL)
+	return shot.UpdateImage(p.DB, finalURL, sha256Hex)
 }
 
-func (p *Processor) handleTTSResult(shotId string, result *models.TaskResult) error {
+func (p *Processor) handleTTSResult(ctx context.Context, taskID, shotId string, result *models.TaskResult) error {
 	objectName := fmt.Sprintf("shots/%s/audio.mp3", shotId)
-	finalURL, err := processResourceToMinIO(result, objectName)
+	finalURL, sha256Hex, err := processResourceToMinIO(ctx, taskID, result, objectName)
 	if err != nil {
 		return fmt.Errorf("处理音频资源失败: %v", err)
 	}
 
+	if _, err := models.GetShotByIDGorm(p.DB, shotId); err != nil {
+		return err
+	}
+
 	log.Printf("音频上传成功: %s", finalURL)
 	return p.DB.Model(&models.Shot{}).Where("id = ?", shotId).Updates(map[string]interface{}{
-		"audio_path": finalURL,
-		"updated_at": time.Now(),
+		"audio_path":   finalURL,
+		"audio_sha256": sha256Hex,
+		"updated_at":   time.Now(),
 	}).Error
 }
 
-// 处理视频生成结果 -> 更新 VideoUrl
-func (p *Processor) handleVideoResult(shotID string, result *models.TaskResult) error {
+// 处理视频生成结果 -> 更新 Shot 状态
+// 产物审核同样交给 SubmitModerationFollowUp 创建的 TaskTypeModeration 任务统一处理（见
+// handleImageResult 顶部注释），这里不再重复提交 SubmitVideoFrames。
+func (p *Processor) handleVideoResult(ctx context.Context, taskID, shotID string, result *models.TaskResult) error {
 	objectName := fmt.Sprintf("shots/%s/video.mp4", shotID)
-	finalURL, err := processResourceToMinIO(result, objectName)
+	finalURL, sha256Hex, err := processResourceToMinIO(ctx, taskID, result, objectName)
 	if err != nil {
 		return fmt.Errorf("处理视频资源失败: %v", err)
 	}
 
+	if _, err := models.GetShotByIDGorm(p.DB, shotID); err != nil {
+		return err
+	}
+
 	log.Printf("视频上传成功: %s", finalURL)
+	// shot 表没有 video_url 列（成片 URL 按 shots/<shotID>/video.mp4 的固定 object key 现取现签，
+	// 见 oss.go），只落 video_sha256，供前端在预签名 URL 轮换后仍能稳定 cache-busting
+	if err := p.DB.Model(&models.Shot{}).Where("id = ?", shotID).Updates(map[string]interface{}{
+		"status":       models.ShotStatusCompleted,
+		"video_sha256": sha256Hex,
+		"updated_at":   time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+
+	// 成片入库后再做 HLS 切片打包；打包失败不影响视频本身已完成的状态，只记录日志
+	if err := p.packageAndUploadHLS(shotID, finalURL); err != nil {
+		log.Printf("HLS 打包失败 shot=%s: %v", shotID, err)
+	}
+	return nil
+}
+
+// packageAndUploadHLS 下载成片 mp4、切片成多码率 HLS、上传到 MinIO，并把 master 播放列表
+// 路径写回 Shot，转入 stream_ready 状态
+func (p *Processor) packageAndUploadHLS(shotID, videoURL string) error {
+	tmpDir, err := os.MkdirTemp("", "hls-"+shotID)
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	inputPath := filepath.Join(tmpDir, "input.mp4")
+	if err := downloadToFile(videoURL, inputPath); err != nil {
+		return fmt.Errorf("下载成片失败: %v", err)
+	}
+
+	outDir := filepath.Join(tmpDir, "out")
+	pkg, err := streaming.PackageHLS(inputPath, outDir)
+	if err != nil {
+		return err
+	}
+
+	objectPrefix := fmt.Sprintf("shots/%s/hls", shotID)
+	if _, err := UploadDirToMinIO(pkg.OutputDir, objectPrefix); err != nil {
+		return fmt.Errorf("上传 HLS 产物失败: %v", err)
+	}
+
+	masterObjectName := objectPrefix + "/" + pkg.MasterFileName
 	return p.DB.Model(&models.Shot{}).Where("id = ?", shotID).Updates(map[string]interface{}{
-		"video_url":  finalURL,
-		"status":     models.ShotStatusCompleted,
-		"updated_at": time.Now(),
+		"hls_master_path": masterObjectName,
+		"status":          models.ShotStatusStreamReady,
+		"updated_at":      time.Now(),
 	}).Error
 }
 
-// processResourceToMinIO 通用资源处理函数
-func processResourceToMinIO(result *models.TaskResult, objectName string) (string, error) {
+// downloadToFile 把一个 HTTP URL 的内容下载到本地文件，供 ffmpeg 读取
+func downloadToFile(sourceURL, destPath string) error {
+	resp, err := http.Get(sourceURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download status: %d", resp.StatusCode)
+	}
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
+}
+
+func toModerationRemarks(remarks []moderation.Remark) models.ModerationRemarks {
+	out := make(models.ModerationRemarks, 0, len(remarks))
+	for _, r := range remarks {
+		out = append(out, models.ModerationRemark{Category: r.Category, Score: r.Score, Detail: r.Detail})
+	}
+	return out
+}
+
+func toModerationScores(scores moderation.CategoryScores) models.ModerationScores {
+	return models.ModerationScores{Porn: scores.Porn, Violence: scores.Violence, Political: scores.Political, Other: scores.Other}
+}
+
+// processResourceToMinIO 通用资源处理函数：先把 worker 产物下载到本地临时文件，
+// 再走断点续传的分片上传，这样大视频文件中途失败/被取消时不用从头重传。返回
+// (预签名 URL, 文件内容的 sha256 十六进制摘要, error)，调用方把摘要落到对应的
+// Shot.Image/Audio/VideoSha256，供前端在预签名 URL 轮换后仍能做 cache-busting。
+func processResourceToMinIO(ctx context.Context, taskID string, result *models.TaskResult, objectName string) (string, string, error) {
 	resourceUrl := result.ResourceUrl
 	if resourceUrl == "" {
-		return "", fmt.Errorf("resourceUrl is empty")
+		return "", "", fmt.Errorf("resourceUrl is empty")
 	}
-	return downloadAndUploadToMinIO(resourceUrl, objectName)
+	return downloadAndUploadToMinIO(ctx, taskID, resourceUrl, objectName)
 }
 
-func downloadAndUploadToMinIO(sourceURL, objectName string) (string, error) {
-	resp, err := http.Get(sourceURL)
+func downloadAndUploadToMinIO(ctx context.Context, taskID, sourceURL, objectName string) (string, string, error) {
+	tmpDir, err := os.MkdirTemp("", "upload-*")
 	if err != nil {
-		return "", fmt.Errorf("download failed: %v", err)
+		return "", "", fmt.Errorf("创建临时目录失败: %v", err)
 	}
-	defer resp.Body.Close()
+	defer os.RemoveAll(tmpDir)
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("download status: %d", resp.StatusCode)
+	localPath := filepath.Join(tmpDir, filepath.Base(objectName))
+	if err := downloadToFileCtx(ctx, sourceURL, localPath); err != nil {
+		return "", "", fmt.Errorf("download failed: %v", err)
 	}
 
-	return UploadToMinIO(resp.Body, objectName, resp.ContentLength)
+	finalURL, sha256Hex, err := UploadToMinIOResumable(ctx, taskID, localPath, objectName)
+	if err != nil {
+		return "", "", fmt.Errorf("upload failed: %v", err)
+	}
+	log.Printf("资源上传完成: object=%s sha256=%s", objectName, sha256Hex)
+	return finalURL, sha256Hex, nil
+}
+
+// downloadToFileCtx 与 downloadToFile 相同，但支持通过 ctx 取消下载
+func downloadToFileCtx(ctx context.Context, sourceURL, destPath string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download status: %d", resp.StatusCode)
+	}
+	out, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, resp.Body)
+	return err
 }
 
 // 工具函数：安全获取 string
@@ -6,12 +6,21 @@ import (
 	"log"
 	"time"
     "StoryToVideo-server/config"
+    "StoryToVideo-server/models"
 
 	"github.com/hibiken/asynq"
 )
 
 const (
-    TypeGenerateTask = "task:generate" 
+    TypeGenerateTask = "task:generate"
+)
+
+// 三档 asynq 优先级队列，task.Priority 按 priorityQueueBounds 映射到其中一档；
+// StartProcessor 按同样的名字配置各档的处理权重，数值越小（critical）的任务被处理得越频繁。
+const (
+	QueueCritical = "critical"
+	QueueDefault  = "default"
+	QueueLow      = "low"
 )
 
 type TaskPayload struct {
@@ -26,26 +35,72 @@ func InitQueue() {
         Addr:     config.AppConfig.Redis.Addr,
         Password: config.AppConfig.Redis.Password,
     })
+    startStallReaper(models.GormDB, stallSweepInterval)
+}
+
+// priorityQueueName 把 task.Priority（越小越紧急）分到三档 asynq 队列里，近似实现按
+// (priority, created_at) 调度：同一档内 asynq 按 FIFO 处理，档之间由 Queues 权重决定
+// 处理频率，数值边界对应 models.DefaultPriority 给出的默认值（storyboard=10 ... video=30）。
+func priorityQueueName(priority int) string {
+	switch {
+	case priority <= 15:
+		return QueueCritical
+	case priority <= 25:
+		return QueueDefault
+	default:
+		return QueueLow
+	}
 }
 
-// EnqueueGenerateTask 通用的生成任务入队接口
+// EnqueueTask 把任务放进 asynq 对应优先级的队列；如果任务类型当前 processing 数已经达到
+// config.AppConfig.Scheduler.TypeConcurrency（或 DefaultTypeConcurrency）的上限，这次先不
+// 入队，留给 service.StartTaskScheduler 下一轮扫描时再放行——避免单个项目的一批同类型任务
+// 把 worker 全占满，饿死其它项目。
 func EnqueueTask(taskID string) error {
+	task, err := models.GetTaskByIDGorm(models.GormDB, taskID)
+	if err != nil {
+		return fmt.Errorf("加载 task 失败: %w", err)
+	}
+
+	if quota := typeQuotaFor(task.Type); quota > 0 {
+		inFlight, err := models.CountTasksByStatusAndType(models.GormDB, models.TaskStatusProcessing, task.Type)
+		if err != nil {
+			return fmt.Errorf("查询 %s 类型在跑任务数失败: %w", task.Type, err)
+		}
+		if inFlight >= int64(quota) {
+			log.Printf("[Queue] 任务 %s (type=%s) 已达并发上限 %d，推迟入队", taskID, task.Type, quota)
+			return nil
+		}
+	}
+
     payload, err := json.Marshal(TaskPayload{TaskID: taskID})
     if err != nil {
         return fmt.Errorf("marshal payload failed: %w", err)
     }
 
-    task := asynq.NewTask(TypeGenerateTask, payload,
+    asynqTask := asynq.NewTask(TypeGenerateTask, payload,
         asynq.MaxRetry(3),                      // 失败重试 3 次
         asynq.Timeout(20*time.Minute),          // 显卡生成较慢，设置较长超时
         asynq.Retention(24*time.Hour),          // 任务结果在 Redis 保留时间
+        asynq.Queue(priorityQueueName(task.Priority)),
     )
 
-    info, err := QueueClient.Enqueue(task)
+    info, err := QueueClient.Enqueue(asynqTask)
     if err != nil {
         return fmt.Errorf("enqueue failed: %w", err)
     }
-    
-    log.Printf("[Queue] Task Enqueued: ID=%s, TaskID=%s", taskID, info.ID)
+
+    log.Printf("[Queue] Task Enqueued: ID=%s, TaskID=%s, Priority=%d, Queue=%s", taskID, info.ID, task.Priority, info.Queue)
     return nil
+}
+
+// typeQuotaFor 读取某类型的并发上限配置，未显式配置时按 DefaultTypeConcurrency 兜底
+func typeQuotaFor(taskType string) int {
+	if config.AppConfig == nil {
+		return models.DefaultTypeConcurrency(taskType)
+	}
+	if quota, ok := config.AppConfig.Scheduler.TypeConcurrency[taskType]; ok {
+		return quota
+	}
+	return models.DefaultTypeConcurrency(taskType)
 }
\ No newline at end of file
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"StoryToVideo-server/models"
+
+	"gorm.io/gorm"
+)
+
+// StartRetrySweeper 周期性地把 next_retry_at 已到期的 retry_scheduled 任务重新放回 pending
+// 并入队，是失败重试链路的收尾：task.UpdateStatus/ResolveTaskFailure 只负责把任务标记为
+// retry_scheduled 并算出下次重试时间，真正触发下一次尝试由这里负责。
+func StartRetrySweeper(db *gorm.DB, interval time.Duration) {
+	log.Printf("Starting Retry Sweeper with interval %s...", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweepDueRetries(db)
+		}
+	}()
+}
+
+func sweepDueRetries(db *gorm.DB) {
+	due, err := models.GetDueRetryTasks(db, time.Now())
+	if err != nil {
+		log.Printf("[RetrySweeper] 查询待重试任务失败: %v", err)
+		return
+	}
+	for _, task := range due {
+		if err := models.UpdateTaskStatus(task.ID, models.TaskStatusPending, nil, nil, nil, nil, nil, nil); err != nil {
+			log.Printf("[RetrySweeper] 任务 %s 重置为 pending 失败: %v", task.ID, err)
+			continue
+		}
+		PublishTaskEvent(TaskEvent{TaskID: task.ID, ProjectID: task.ProjectId, Status: models.TaskStatusPending, Progress: task.Progress, Message: task.Message})
+		if err := EnqueueTask(task.ID); err != nil {
+			log.Printf("[RetrySweeper] 任务 %s 重新入队失败: %v", task.ID, err)
+			continue
+		}
+		log.Printf("[RetrySweeper] 任务 %s 第 %d 次重试已入队", task.ID, task.Attempts)
+	}
+}
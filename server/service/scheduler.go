@@ -0,0 +1,51 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"StoryToVideo-server/config"
+	"StoryToVideo-server/models"
+
+	"gorm.io/gorm"
+)
+
+// schedulerBatchSize 是每轮调度最多放行的任务数，避免一次性把大量任务推给 asynq
+const schedulerBatchSize = 50
+
+// StartTaskScheduler 周期性地调用 models.FetchNextRunnableTasks 挑出依赖已满足、且类型未
+// 超过并发上限的 pending 任务并入队：EnqueueTask 本身只会在任务类型未超配额时才真正放入
+// asynq，这里负责把之前因为超配额被跳过的任务在配额空出来后重新推进，是 chunk1-3 优先级/
+// 并发限流调度的收尾，和 StartRetrySweeper 收尾失败重试是同一种结构。
+func StartTaskScheduler(db *gorm.DB, interval time.Duration) {
+	log.Printf("Starting Task Scheduler with interval %s...", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweepRunnableTasks(db)
+		}
+	}()
+}
+
+func sweepRunnableTasks(db *gorm.DB) {
+	runnable, err := models.FetchNextRunnableTasks(db, schedulerBatchSize, typeQuotas())
+	if err != nil {
+		log.Printf("[TaskScheduler] 查询可运行任务失败: %v", err)
+		return
+	}
+	for _, task := range runnable {
+		if err := EnqueueTask(task.ID); err != nil {
+			log.Printf("[TaskScheduler] 任务 %s 入队失败: %v", task.ID, err)
+		}
+	}
+}
+
+// typeQuotas 从配置里读出每种类型的并发上限，交给 FetchNextRunnableTasks 做准入过滤；
+// 未在配置里出现的类型由 FetchNextRunnableTasks 自己按 models.DefaultTypeConcurrency 兜底
+func typeQuotas() map[string]int {
+	if config.AppConfig == nil {
+		return nil
+	}
+	return config.AppConfig.Scheduler.TypeConcurrency
+}
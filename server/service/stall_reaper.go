@@ -0,0 +1,98 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"StoryToVideo-server/config"
+	"StoryToVideo-server/models"
+	"StoryToVideo-server/service/orchestrator"
+
+	"gorm.io/gorm"
+)
+
+// defaultHeartbeatInterval 是 config.AppConfig.Worker.Heartbeats 里没有为某个 task.Type
+// 显式配置心跳间隔时的兜底值
+const defaultHeartbeatInterval = 60 * time.Second
+
+// stallMultiplier 超过 heartbeatInterval 这么多倍没收到心跳才判定为 stalled，留出网络抖动/
+// GC 停顿的余量，不是一超时就判死刑
+const stallMultiplier = 3
+
+// stallSweepInterval 是 StallReaper 扫描 processing 任务心跳的周期
+const stallSweepInterval = 15 * time.Second
+
+// heartbeatInterval 读取配置里某个 task.Type 的心跳间隔，未配置时按 defaultHeartbeatInterval
+// 兜底
+func heartbeatInterval(taskType string) time.Duration {
+	if config.AppConfig != nil {
+		if d, ok := config.AppConfig.Worker.Heartbeats[taskType]; ok && d > 0 {
+			return d
+		}
+	}
+	return defaultHeartbeatInterval
+}
+
+// startStallReaper 周期性扫描所有 processing 任务：updated_at（worker 应该通过
+// PATCH /v1/api/tasks/:task_id/heartbeat 定期刷新）超过 heartbeatInterval*3 没更新的，
+// 视为对应的 worker 已经崩溃/失联，取消 worker 侧的 job 并按普通失败路径标记 failed——复用
+// Task.UpdateStatus 里已有的 attempts/max_attempts/退避计算，真正的重新入队仍交给
+// StartRetrySweeper 统一负责。不这么做的话，一个崩溃的 GPU worker 会让任务永远卡在
+// processing，WebSocket 进度也永远不会结束。
+func startStallReaper(db *gorm.DB, interval time.Duration) {
+	log.Printf("Starting Stall Reaper with interval %s...", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			sweepStalledTasks(db)
+		}
+	}()
+}
+
+func sweepStalledTasks(db *gorm.DB) {
+	processing, err := models.GetProcessingTasks(db)
+	if err != nil {
+		log.Printf("[StallReaper] 查询 processing 任务失败: %v", err)
+		return
+	}
+	now := time.Now()
+	for i := range processing {
+		task := &processing[i]
+		threshold := heartbeatInterval(task.Type) * stallMultiplier
+		if now.Sub(task.UpdatedAt) < threshold {
+			continue
+		}
+		reapStalledTask(db, task)
+	}
+}
+
+func reapStalledTask(db *gorm.DB, task *models.Task) {
+	if task.Result.ResourceId != "" {
+		if err := CancelWorkerJob(task.Result.ResourceId); err != nil {
+			log.Printf("[StallReaper] 取消 worker job %s 失败: %v", task.Result.ResourceId, err)
+		}
+	}
+	if err := task.UpdateStatus(db, models.TaskStatusFailed, nil, "stalled: no heartbeat"); err != nil {
+		log.Printf("[StallReaper] 标记任务 %s 失败失败: %v", task.ID, err)
+		return
+	}
+	PublishTaskUpdate(task)
+	if err := orchestrator.OnTaskFailed(db, task.ID, orchestratorFailurePolicy(), PublishStatusEvent); err != nil {
+		log.Printf("orchestrator: 级联取消依赖任务失败: %v", err)
+	}
+
+	// UpdateStatus 可能把它改判为 retry_scheduled（还有重试次数）而不是终态 failed，重新加载
+	// 一次确认落地状态，避免还会被 RetrySweeper 捡回去的分片被提前计入批次的 FailedShards
+	reloaded, err := models.GetTaskByIDGorm(db, task.ID)
+	if err != nil {
+		log.Printf("[StallReaper] 重新加载任务 %s 失败: %v", task.ID, err)
+		return
+	}
+	if reloaded.Status == models.TaskStatusFailed {
+		if err := OnShardTaskFailed(db, reloaded); err != nil {
+			log.Printf("batch: 上报分片失败失败: %v", err)
+		}
+	}
+	log.Printf("[StallReaper] 任务 %s (type=%s) 心跳超时，已标记为 %s", task.ID, task.Type, reloaded.Status)
+}
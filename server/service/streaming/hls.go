@@ -0,0 +1,110 @@
+// Package streaming 负责把单个分镜渲染好的 MP4 打包成 HLS 自适应码率梯子（480p/720p/1080p），
+// 并提供解析/重写播放列表所需的工具函数，供 routers/api 在请求时现签分片 URL。
+package streaming
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Variant 描述 HLS 自适应码率梯子中的一档
+type Variant struct {
+	Name    string // 目录名，同时也是对外暴露的 ?variant= 取值，例如 "720p"
+	Width   int
+	Height  int
+	Bitrate string // ffmpeg -b:v 参数，例如 "2800k"
+}
+
+// DefaultLadder 是默认的三档码率梯子
+var DefaultLadder = []Variant{
+	{Name: "480p", Width: 854, Height: 480, Bitrate: "800k"},
+	{Name: "720p", Width: 1280, Height: 720, Bitrate: "2800k"},
+	{Name: "1080p", Width: 1920, Height: 1080, Bitrate: "5000k"},
+}
+
+// PackageResult 描述一次打包的产物位置
+type PackageResult struct {
+	OutputDir      string // 本地临时目录，结构为 {variant}/index.m3u8 + seg_*.ts + master.m3u8
+	MasterFileName string // 相对 OutputDir 的 master 播放列表文件名，固定为 "master.m3u8"
+}
+
+// PackageHLS 对本地 MP4 文件执行多码率 HLS 切片，产物写入 outDir。
+// 每个分片 4 秒（hls_time=4），采用 VOD 播放列表类型。
+func PackageHLS(inputPath, outDir string) (*PackageResult, error) {
+	for _, v := range DefaultLadder {
+		variantDir := filepath.Join(outDir, v.Name)
+		if err := os.MkdirAll(variantDir, 0o755); err != nil {
+			return nil, fmt.Errorf("创建码率目录 %s 失败: %w", v.Name, err)
+		}
+
+		args := []string{
+			"-y", "-i", inputPath,
+			"-vf", fmt.Sprintf("scale=%d:%d", v.Width, v.Height),
+			"-c:v", "libx264", "-profile:v", "main", "-crf", "20",
+			"-c:a", "aac", "-ar", "48000",
+			"-b:v", v.Bitrate, "-maxrate", v.Bitrate, "-bufsize", doubleBitrate(v.Bitrate),
+			"-sc_threshold", "0", "-g", "48", "-keyint_min", "48",
+			"-hls_time", "4", "-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(variantDir, "seg_%03d.ts"),
+			filepath.Join(variantDir, "index.m3u8"),
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("ffmpeg", args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("ffmpeg 转码 %s 失败: %v, stderr: %s", v.Name, err, stderr.String())
+		}
+	}
+
+	masterPath := filepath.Join(outDir, "master.m3u8")
+	if err := writeMasterPlaylist(masterPath, DefaultLadder); err != nil {
+		return nil, err
+	}
+	return &PackageResult{OutputDir: outDir, MasterFileName: "master.m3u8"}, nil
+}
+
+func writeMasterPlaylist(path string, variants []Variant) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, v := range variants {
+		b.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n%s/index.m3u8\n",
+			bandwidthFromBitrate(v.Bitrate), v.Width, v.Height, v.Name))
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// doubleBitrate 把 "2800k" 形式的码率翻倍，作为 ffmpeg -bufsize 的经验取值
+func doubleBitrate(bitrate string) string {
+	n, unit := splitBitrate(bitrate)
+	return strconv.Itoa(n*2) + unit
+}
+
+// bandwidthFromBitrate 把 "2800k" 换算成 EXT-X-STREAM-INF 要求的 bps 整数
+func bandwidthFromBitrate(bitrate string) int {
+	n, unit := splitBitrate(bitrate)
+	if unit == "k" {
+		return n * 1000
+	}
+	if unit == "m" {
+		return n * 1000 * 1000
+	}
+	return n
+}
+
+func splitBitrate(bitrate string) (int, string) {
+	bitrate = strings.ToLower(strings.TrimSpace(bitrate))
+	unit := ""
+	numPart := bitrate
+	if strings.HasSuffix(bitrate, "k") || strings.HasSuffix(bitrate, "m") {
+		unit = bitrate[len(bitrate)-1:]
+		numPart = bitrate[:len(bitrate)-1]
+	}
+	n, _ := strconv.Atoi(numPart)
+	return n, unit
+}
@@ -0,0 +1,77 @@
+package streaming
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Segment 是从某个 variant 的 index.m3u8 中解析出来的一个分片条目
+type Segment struct {
+	Duration float64
+	Filename string // 相对该 variant 目录的文件名，例如 "seg_000.ts"
+}
+
+// ParseVariantSegments 解析 variant index.m3u8 原始内容，抽取 #EXTINF + 分片文件名
+func ParseVariantSegments(content string) []Segment {
+	var segments []Segment
+	lines := strings.Split(content, "\n")
+	var pendingDuration float64
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#EXTINF:"):
+			raw := strings.TrimPrefix(line, "#EXTINF:")
+			raw = strings.TrimSuffix(raw, ",")
+			if d, err := strconv.ParseFloat(strings.Split(raw, ",")[0], 64); err == nil {
+				pendingDuration = d
+			}
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		default:
+			segments = append(segments, Segment{Duration: pendingDuration, Filename: line})
+		}
+	}
+	return segments
+}
+
+// RewriteVariantPlaylist 把一个 variant index.m3u8 中的分片文件名替换为 presign 生成的绝对 URL。
+// presign 接收分片相对该 variant 目录的文件名，返回该分片在 MinIO 中对应对象的预签名 URL。
+func RewriteVariantPlaylist(content string, presign func(filename string) (string, error)) (string, error) {
+	lines := strings.Split(content, "\n")
+	var out strings.Builder
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out.WriteString(line)
+			out.WriteString("\n")
+			continue
+		}
+		url, err := presign(trimmed)
+		if err != nil {
+			return "", fmt.Errorf("为分片 %s 签名失败: %w", trimmed, err)
+		}
+		out.WriteString(url)
+		out.WriteString("\n")
+	}
+	return out.String(), nil
+}
+
+// RewriteMasterPlaylist 把 master.m3u8 中每个 "{variant}/index.m3u8" 形式的引用，
+// 替换为 variantURL 生成的、指向本服务 variant 子播放列表代理路由的绝对 URL。
+func RewriteMasterPlaylist(content string, variantURL func(variant string) string) string {
+	lines := strings.Split(content, "\n")
+	var out strings.Builder
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out.WriteString(line)
+			out.WriteString("\n")
+			continue
+		}
+		variant := strings.TrimSuffix(trimmed, "/index.m3u8")
+		out.WriteString(variantURL(variant))
+		out.WriteString("\n")
+	}
+	return out.String()
+}